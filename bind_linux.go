@@ -0,0 +1,56 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToDevice binds the socket c to the network interface iface with
+// SO_BINDTODEVICE, so its traffic leaves through iface whatever the
+// routes say. Kernels before 5.7 require CAP_NET_RAW for it.
+func bindToDevice(c syscall.RawConn, iface string) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.BindToDevice(int(fd), iface)
+	}); cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return fmt.Errorf("binding to interface %s: %w", iface, err)
+	}
+	return nil
+}
+
+// reuseAddr sets SO_REUSEADDR on the socket c, so clients can bind to their
+// port while connections of earlier runs from it linger in TIME_WAIT.
+func reuseAddr(c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return fmt.Errorf("setting SO_REUSEADDR: %w", err)
+	}
+	return nil
+}
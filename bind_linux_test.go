@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBindToDevice(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	o.Interface = "lo"
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	conn, err := o.Dial()
+	if err != nil {
+		// kernels before 5.7 require CAP_NET_RAW
+		t.Skipf("cannot bind to %s: %v", o.Interface, err)
+	}
+	conn.Close()
+
+	o.Interface = "benchmate-none"
+	if _, err := o.Dial(); err == nil {
+		t.Errorf("expected binding to missing interface %s to fail", o.Interface)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"errors"
+	"syscall"
+)
+
+// bindToDevice fails since binding sockets to an interface is only
+// supported on Linux.
+func bindToDevice(c syscall.RawConn, iface string) error {
+	return errors.New("binding to an interface is only supported on linux")
+}
+
+// reuseAddr does nothing, clients only reuse their port while connections
+// of earlier runs linger in TIME_WAIT on Linux.
+func reuseAddr(c syscall.RawConn) error {
+	return nil
+}
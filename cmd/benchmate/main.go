@@ -22,25 +22,92 @@ limitations under the License.
 // 	$ benchmate -c
 //
 // As long as the client can talk to the server, you will get estimates at the client.
+// The server serves latency and throughput tests on a single port, 13500 by default.
 //
 //	Usage of ./benchmate:
 //		-addr string
 //			set the address (default ":12345")
+//		-bitrate string
+//			set the target bitrate (bits/s) of throughput runs, e.g. 200Mbit, unlimited by default except over udp
 //		-c	set the flag to run in client mode. Default is server mode.
 //		-clientPort int
-//			set the client port (valid only in client mode)
+//			set the client port, only one connection can use it at a time (valid only in client mode)
+//		-concurrent
+//			set the flag to serve clients concurrently in daemon mode
+//		-congestion string
+//			set the TCP congestion control algorithm, e.g. cubic, bbr or reno, Linux only
+//		-connect
+//			set the flag to open a new connection for every transaction of transaction runs, the server must run in daemon mode
+//		-cork
+//			set the flag to set TCP_CORK, Linux only
+//		-cps
+//			set the flag to run in connect mode, which measures connect times and connections per second, and specify the options on command line, the server must run in daemon mode
+//		-daemon
+//			set the flag to keep the server running for any number of clients until it is interrupted
+//		-direction string
+//			set the direction of throughput runs (forward, reverse or bidir) (default "forward")
+//		-duration int
+//			set the duration (ms) of throughput runs, overrides numMsg and timeout
+//		-interface string
+//			set the network interface the client is bound to, Linux only (valid only in client mode)
+//		-interval int
+//			set the interval (ms) for progress reports of the client, 0 disables them
 //		-lat
 //			set the flag to run in latency mode and specify the options on command line
 //		-latOpt string
 //			set the latency options using json file
+//		-load
+//			set the flag to measure the latency while a throughput run loads the network (valid only in client mode), the options are taken from the json files or the defaults, -addr, -network, -duration and -parallel apply to both
+//		-maxSegment int
+//			set the TCP maximum segment size (TCP_MAXSEG) in bytes, not on Windows
+//		-maxSize int
+//			set the largest message size of a sweep (default 4194304)
+//		-minSize int
+//			set the smallest message size of a sweep (default 64)
 //		-msgSize int
 //			set the message size (default 1024)
 //		-network string
-//			set the network (tcp or unix) (default "tcp")
+//			set the network (tcp, unix, tls, udp or unixgram), tls needs the TLS flags (default "tcp")
+//		-noDelay
+//			set TCP_NODELAY, pass -noDelay=false to enable Nagle's algorithm (default true)
+//		-notSentLowat int
+//			set TCP_NOTSENT_LOWAT in bytes, Linux only
 //		-numMsg int
 //			set the number of messages to exchange (default 1000)
+//		-parallel int
+//			set the number of parallel streams of throughput runs or dialers of connect runs (default 1)
+//		-pingTimeout int
+//			set the timeout (ms) of a ping of latency runs over udp or unixgram, pings without a reply in time are lost
+//		-rate int
+//			set the rate (pings/s) of open-loop latency runs, 0 sends the next ping once the reply arrived
+//		-receiveBuffer int
+//			set the socket receive buffer size (SO_RCVBUF) in bytes, the default of the kernel if 0
+//		-reqSize int
+//			set the request size of transaction runs, msgSize by default
+//		-respSize int
+//			set the response size of transaction runs, msgSize by default
+//		-rr
+//			set the flag to run in transaction mode, which measures request/response transactions per second, and specify the options on command line
+//		-sendBuffer int
+//			set the socket send buffer size (SO_SNDBUF) in bytes, the default of the kernel if 0
+//		-sourceAddr string
+//			set the source IP address of the client (valid only in client mode)
+//		-sweep
+//			set the flag to run the tests with message sizes from -minSize to -maxSize in powers of two (valid only in client mode), -lat or -tp select a single test, the options are taken from the json files or the defaults, -addr, -network, -duration and -numMsg of latency tests apply
 //		-timeout int
 //			set the timeout (ms) (default 120000)
+//		-tlsCA string
+//			set the PEM file of the CAs verifying the TLS peer, servers require client certificates if set
+//		-tlsCert string
+//			set the PEM certificate file to run the tests over TLS, required by servers, clients present it to servers requiring client certificates
+//		-tlsCipherSuites string
+//			set the comma separated names of the cipher suites enabled up to TLS 1.2, the defaults of Go by default
+//		-tlsKey string
+//			set the PEM private key file of the TLS certificate
+//		-tlsMinVersion string
+//			set the minimum TLS version (1.0, 1.1, 1.2 or 1.3), 1.2 by default
+//		-tlsServerName string
+//			set the name the TLS server certificate is verified against, the host of -addr by default (valid only in client mode)
 //		-tp
 //			set the flag to run in throughput mode and specify the options on command line
 //		-tpOpt string
@@ -54,11 +121,15 @@ package main
 import (
 	"encoding/json"
 	"flag"
-	"io"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/kubermatic/benchmate"
@@ -67,39 +138,141 @@ import (
 func main() {
 	log.SetFlags(0)
 	var c bool
+	var daemon bool
 
 	var latOptFile string
 	var tpOptFile string
 
 	var lat bool
 	var tp bool
+	var rr bool
+	var cps bool
+	var load bool
+	var sweep bool
+	var minSize, maxSize int
 
 	var (
-		msgSize    int
-		numMsg     int
-		addr       string
-		network    string
-		clientPort int
-		timeout    int
+		msgSize     int
+		numMsg      int
+		addr        string
+		network     string
+		clientPort  int
+		sourceAddr  string
+		iface       string
+		timeout     int
+		duration    int
+		interval    int
+		direction   string
+		parallel    int
+		concurrent  bool
+		bitrate     string
+		pingTimeout int
+		rate        int
+		reqSize     int
+		respSize    int
+		connect     bool
+
+		tlsCert         string
+		tlsKey          string
+		tlsCA           string
+		tlsServerName   string
+		tlsMinVersion   string
+		tlsCipherSuites string
+
+		sendBuffer    int
+		receiveBuffer int
+		noDelay       bool
+		cork          bool
+		congestion    string
+		maxSegment    int
+		notSentLowat  int
 	)
 
 	flag.BoolVar(&c, "c", false, "set the flag to run in client mode. Default is server mode. ")
+	flag.BoolVar(&daemon, "daemon", false, "set the flag to keep the server running for any number of clients until it is interrupted")
+	flag.BoolVar(&concurrent, "concurrent", false, "set the flag to serve clients concurrently in daemon mode")
 
 	flag.StringVar(&latOptFile, "latOpt", "", "set the latency options using json file")
 	flag.StringVar(&tpOptFile, "tpOpt", "", "set the throughput options using json file")
 
 	flag.BoolVar(&lat, "lat", false, "set the flag to run in latency mode and specify the options on command line")
 	flag.BoolVar(&tp, "tp", false, "set the flag to run in throughput mode and specify the options on command line")
+	flag.BoolVar(&rr, "rr", false, "set the flag to run in transaction mode, which measures request/response transactions per second, and specify the options on command line")
+	flag.BoolVar(&cps, "cps", false, "set the flag to run in connect mode, which measures connect times and connections per second, and specify the options on command line, the server must run in daemon mode")
+	flag.BoolVar(&sweep, "sweep", false, "set the flag to run the tests with message sizes from -minSize to -maxSize in powers of two (valid only in client mode), -lat or -tp select a single test, the options are taken from the json files or the defaults, -addr, -network, -duration and -numMsg of latency tests apply")
+	flag.IntVar(&minSize, "minSize", benchmate.DefaultSweepMinSize, "set the smallest message size of a sweep")
+	flag.IntVar(&maxSize, "maxSize", benchmate.DefaultSweepMaxSize, "set the largest message size of a sweep")
+	flag.BoolVar(&load, "load", false, "set the flag to measure the latency while a throughput run loads the network (valid only in client mode), the options are taken from the json files or the defaults, -addr, -network, -duration and -parallel apply to both")
 
 	flag.IntVar(&msgSize, "msgSize", 1024, "set the message size")
 	flag.IntVar(&numMsg, "numMsg", 1000, "set the number of messages to exchange")
 	flag.StringVar(&addr, "addr", ":12345", "set the address")
-	flag.StringVar(&network, "network", "tcp", "set the network (tcp or unix)")
-	flag.IntVar(&clientPort, "clientPort", 0, "set the client port (valid only in client mode)")
+	flag.StringVar(&network, "network", "tcp", "set the network (tcp, unix, tls, udp or unixgram), tls needs the TLS flags")
+	flag.IntVar(&clientPort, "clientPort", 0, "set the client port, only one connection can use it at a time (valid only in client mode)")
+	flag.StringVar(&sourceAddr, "sourceAddr", "", "set the source IP address of the client (valid only in client mode)")
+	flag.StringVar(&iface, "interface", "", "set the network interface the client is bound to, Linux only (valid only in client mode)")
 	flag.IntVar(&timeout, "timeout", 120000, "set the timeout (ms)")
+	flag.IntVar(&duration, "duration", 0, "set the duration (ms) of throughput runs, overrides numMsg and timeout")
+	flag.StringVar(&direction, "direction", benchmate.DirectionForward, "set the direction of throughput runs (forward, reverse or bidir)")
+	flag.IntVar(&parallel, "parallel", 1, "set the number of parallel streams of throughput runs or dialers of connect runs")
+	flag.IntVar(&interval, "interval", 0, "set the interval (ms) for progress reports of the client, 0 disables them")
+	flag.StringVar(&bitrate, "bitrate", "", "set the target bitrate (bits/s) of throughput runs, e.g. 200Mbit, unlimited by default except over udp")
+	flag.IntVar(&pingTimeout, "pingTimeout", 0, "set the timeout (ms) of a ping of latency runs over udp or unixgram, pings without a reply in time are lost")
+	flag.IntVar(&reqSize, "reqSize", 0, "set the request size of transaction runs, msgSize by default")
+	flag.IntVar(&respSize, "respSize", 0, "set the response size of transaction runs, msgSize by default")
+	flag.BoolVar(&connect, "connect", false, "set the flag to open a new connection for every transaction of transaction runs, the server must run in daemon mode")
+	flag.StringVar(&tlsCert, "tlsCert", "", "set the PEM certificate file to run the tests over TLS, required by servers, clients present it to servers requiring client certificates")
+	flag.StringVar(&tlsKey, "tlsKey", "", "set the PEM private key file of the TLS certificate")
+	flag.StringVar(&tlsCA, "tlsCA", "", "set the PEM file of the CAs verifying the TLS peer, servers require client certificates if set")
+	flag.StringVar(&tlsServerName, "tlsServerName", "", "set the name the TLS server certificate is verified against, the host of -addr by default (valid only in client mode)")
+	flag.StringVar(&tlsMinVersion, "tlsMinVersion", "", "set the minimum TLS version (1.0, 1.1, 1.2 or 1.3), 1.2 by default")
+	flag.StringVar(&tlsCipherSuites, "tlsCipherSuites", "", "set the comma separated names of the cipher suites enabled up to TLS 1.2, the defaults of Go by default")
+	flag.IntVar(&sendBuffer, "sendBuffer", 0, "set the socket send buffer size (SO_SNDBUF) in bytes, the default of the kernel if 0")
+	flag.IntVar(&receiveBuffer, "receiveBuffer", 0, "set the socket receive buffer size (SO_RCVBUF) in bytes, the default of the kernel if 0")
+	flag.BoolVar(&noDelay, "noDelay", true, "set TCP_NODELAY, pass -noDelay=false to enable Nagle's algorithm")
+	flag.BoolVar(&cork, "cork", false, "set the flag to set TCP_CORK, Linux only")
+	flag.StringVar(&congestion, "congestion", "", "set the TCP congestion control algorithm, e.g. cubic, bbr or reno, Linux only")
+	flag.IntVar(&maxSegment, "maxSegment", 0, "set the TCP maximum segment size (TCP_MAXSEG) in bytes, not on Windows")
+	flag.IntVar(&notSentLowat, "notSentLowat", 0, "set TCP_NOTSENT_LOWAT in bytes, Linux only")
+	flag.IntVar(&rate, "rate", 0, "set the rate (pings/s) of open-loop latency runs, 0 sends the next ping once the reply arrived")
 
 	flag.Parse()
 
+	// applyTLS runs the tests over TLS if any TLS flag is passed
+	applyTLS := func(o *benchmate.Options) {
+		t := benchmate.TLSOptions{
+			Cert:       tlsCert,
+			Key:        tlsKey,
+			CA:         tlsCA,
+			ServerName: tlsServerName,
+			MinVersion: tlsMinVersion,
+		}
+		if tlsCipherSuites != "" {
+			t.CipherSuites = strings.Split(tlsCipherSuites, ",")
+		}
+		if t.Cert != "" || t.Key != "" || t.CA != "" || t.ServerName != "" || t.MinVersion != "" || t.CipherSuites != nil {
+			o.TLS = &t
+		}
+	}
+
+	// applySocket tunes the sockets of the tests if any socket flag is passed
+	applySocket := func(o *benchmate.Options) {
+		sock := benchmate.SocketOptions{
+			SendBuffer:    sendBuffer,
+			ReceiveBuffer: receiveBuffer,
+			Cork:          cork,
+			Congestion:    congestion,
+			MaxSegment:    maxSegment,
+			NotSentLowat:  notSentLowat,
+		}
+		if isFlagPassed("noDelay") {
+			sock.NoDelay = &noDelay
+		}
+		if sock != (benchmate.SocketOptions{}) {
+			o.Socket = &sock
+		}
+	}
+
 	var latOpts benchmate.Options
 	if latOptFile != "" {
 		latOpts = benchmate.DefaultLatencyOptions()
@@ -126,6 +299,72 @@ func main() {
 		}
 	}
 
+	// overrideShared overrides the options of tests run together with the
+	// command line flags that apply to all of them
+	overrideShared := func(latOpts, tpOpts *benchmate.Options) {
+		for _, o := range []*benchmate.Options{latOpts, tpOpts} {
+			if isFlagPassed("addr") {
+				o.Addr = addr
+			}
+			if isFlagPassed("network") {
+				o.Network = network
+			}
+			if isFlagPassed("sourceAddr") {
+				o.SourceAddr = sourceAddr
+			}
+			if isFlagPassed("interface") {
+				o.Interface = iface
+			}
+		}
+		if isFlagPassed("duration") {
+			tpOpts.Duration = duration
+		}
+		applyTLS(latOpts)
+		applyTLS(tpOpts)
+		applySocket(latOpts)
+		applySocket(tpOpts)
+	}
+
+	if sweep {
+		if !c {
+			log.Fatal("-sweep is valid only in client mode, run the server in daemon mode")
+		}
+		if latOptFile == "" {
+			latOpts = benchmate.DefaultLatencyOptions()
+			latOpts.NumMsg = 100
+		}
+		if tpOptFile == "" {
+			tpOpts = benchmate.DefaultThroughputOptions()
+			tpOpts.Duration = 1000
+		}
+		overrideShared(&latOpts, &tpOpts)
+		if isFlagPassed("numMsg") {
+			latOpts.NumMsg = numMsg
+		}
+		runSweepClient(latOpts, tpOpts, minSize, maxSize, lat || !tp, tp || !lat)
+		log.Println("done.")
+		return
+	}
+
+	if load {
+		if !c {
+			log.Fatal("-load is valid only in client mode, servers run latency tests under load anyway")
+		}
+		if latOptFile == "" {
+			latOpts = benchmate.DefaultLatencyOptions()
+		}
+		if tpOptFile == "" {
+			tpOpts = benchmate.DefaultThroughputOptions()
+		}
+		overrideShared(&latOpts, &tpOpts)
+		if isFlagPassed("parallel") {
+			tpOpts.Parallel = parallel
+		}
+		runLoadedLatencyClient(latOpts, tpOpts)
+		log.Println("done.")
+		return
+	}
+
 	if c {
 		if latOptFile != "" {
 			runLatencyClient(latOpts)
@@ -134,22 +373,14 @@ func main() {
 			runThroughputClient(tpOpts)
 		}
 	} else {
-		var wg sync.WaitGroup
+		var servers []benchmate.Options
 		if latOptFile != "" {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				runLatencyServer(latOpts)
-			}()
+			servers = append(servers, latOpts)
 		}
 		if tpOptFile != "" {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				runThroughputServer(tpOpts)
-			}()
+			servers = append(servers, tpOpts)
 		}
-		wg.Wait()
+		runServers(servers, daemon)
 	}
 
 	// If options are specified using json files then ignore the command line options.
@@ -160,27 +391,26 @@ func main() {
 	if lat && tp {
 		log.Fatal("cannot run both latency and throughput with command line flags provide options with JSON files using --latOpts, --tpOpts flags instead.")
 	}
+	if rr && (lat || tp) {
+		log.Fatal("cannot run transactions along with latency or throughput with command line flags.")
+	}
+	if cps && (lat || tp || rr) {
+		log.Fatal("cannot run connects along with other tests with command line flags.")
+	}
 
 	// run both benchmarks with default options when nothing is specified
-	if !lat && !tp {
+	if !lat && !tp && !rr && !cps {
 		latOpts := benchmate.DefaultLatencyOptions()
 		tpOpts := benchmate.DefaultThroughputOptions()
+		applyTLS(&latOpts)
+		applyTLS(&tpOpts)
+		applySocket(&latOpts)
+		applySocket(&tpOpts)
 		if c {
 			runLatencyClient(latOpts)
 			runThroughputClient(tpOpts)
 		} else {
-			var wg sync.WaitGroup
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				runLatencyServer(latOpts)
-			}()
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				runThroughputServer(tpOpts)
-			}()
-			wg.Wait()
+			runServers([]benchmate.Options{latOpts, tpOpts}, daemon)
 		}
 		return
 	}
@@ -190,6 +420,10 @@ func main() {
 		opts = benchmate.DefaultLatencyOptions()
 	} else if tp {
 		opts = benchmate.DefaultThroughputOptions()
+	} else if rr {
+		opts = benchmate.DefaultTransactionOptions()
+	} else if cps {
+		opts = benchmate.DefaultConnectOptions()
 	}
 
 	// override the options with command line flags
@@ -204,31 +438,98 @@ func main() {
 	}
 	if isFlagPassed("network") {
 		opts.Network = network
+		if tp && !isFlagPassed("msgSize") && isDatagram(network) {
+			opts.MsgSize = benchmate.DefaultDatagramSize
+		}
 	}
 	if isFlagPassed("clientPort") {
 		opts.ClientPort = clientPort
 	}
+	if isFlagPassed("sourceAddr") {
+		opts.SourceAddr = sourceAddr
+	}
+	if isFlagPassed("interface") {
+		opts.Interface = iface
+	}
 	if isFlagPassed("timeout") {
 		opts.Timeout = timeout
 	}
-
-	if lat {
-		if c {
-			runLatencyClient(opts)
-		} else {
-			runLatencyServer(opts)
+	if isFlagPassed("duration") {
+		opts.Duration = duration
+	}
+	if isFlagPassed("interval") {
+		opts.Interval = interval
+	}
+	if isFlagPassed("direction") {
+		opts.Direction = direction
+	}
+	if isFlagPassed("parallel") {
+		opts.Parallel = parallel
+	}
+	if isFlagPassed("concurrent") {
+		opts.Concurrent = concurrent
+	}
+	if isFlagPassed("pingTimeout") {
+		opts.PingTimeout = pingTimeout
+	}
+	if isFlagPassed("rate") {
+		opts.Rate = rate
+	}
+	if isFlagPassed("reqSize") {
+		opts.ReqSize = reqSize
+	}
+	if isFlagPassed("respSize") {
+		opts.RespSize = respSize
+	}
+	if isFlagPassed("connect") {
+		opts.Connect = connect
+	}
+	if isFlagPassed("bitrate") {
+		b, err := benchmate.ParseBitrate(bitrate)
+		if err != nil {
+			log.Fatal(err)
 		}
+		opts.Bitrate = b
+	}
+	applyTLS(&opts)
+	applySocket(&opts)
+
+	if !c {
+		runServer(opts, daemon, 1)
+	} else if lat {
+		runLatencyClient(opts)
 	} else if tp {
-		if c {
-			runThroughputClient(opts)
-		} else {
-			runThroughputServer(opts)
-		}
+		runThroughputClient(opts)
+	} else if rr {
+		runTransactionClient(opts)
+	} else if cps {
+		runConnectClient(opts)
 	}
 
 	log.Println("done.")
 }
 
+// logSocket prints the options in effect on the socket of a test if known.
+func logSocket(s *benchmate.SocketSettings) {
+	if s == nil {
+		return
+	}
+	log.Printf("socket send/receive buffer: %d/%d bytes", s.SendBuffer, s.ReceiveBuffer)
+	if s.Congestion != "" {
+		log.Printf("tcp congestion control: %s, max segment: %d bytes, nodelay: %v, cork: %v, notsent lowat: %d", s.Congestion, s.MaxSegment, s.NoDelay, s.Cork, s.NotSentLowat)
+	}
+}
+
+// logTCPInfo prints the kernel statistics of the connection of a test if
+// known, prefixed with prefix.
+func logTCPInfo(prefix string, info *benchmate.TCPInfo) {
+	if info == nil {
+		return
+	}
+	log.Printf("%stcp rtt/rttvar: %v/%v, retransmits: %d, lost: %d, reordering: %d", prefix, info.RTT, info.RTTVar, info.Retransmits, info.Lost, info.Reordering)
+	log.Printf("%stcp cwnd: %d, ssthresh: %d, pacing rate: %d B/s, delivery rate: %d B/s", prefix, info.Cwnd, info.Ssthresh, info.PacingRate, info.DeliveryRate)
+}
+
 func isFlagPassed(name string) bool {
 	found := false
 	flag.Visit(func(f *flag.Flag) {
@@ -249,75 +550,282 @@ func prettyJSON(x interface{}) string {
 
 func runThroughputClient(tpOpt benchmate.Options) {
 	log.Println("running throughput client with:", prettyJSON(tpOpt))
-	conn, err := net.Dial(tpOpt.Network, tpOpt.Addr)
-	if err != nil {
-		log.Fatal(err)
+	var conns []net.Conn
+	for i := 0; i == 0 || i < tpOpt.Parallel; i++ {
+		conn, err := tpOpt.Dial()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
 	}
-	defer conn.Close()
 
-	tpResult, err := tpOpt.ThroughputClient().Run(conn)
+	tpResult, err := tpOpt.ThroughputClient().OnInterval(func(r benchmate.ThroughputResult) {
+		log.Printf("[%3d] [%7.2f-%7.2f s] %12d bytes %10.2f MB/s %s", r.Stream, r.Start.Seconds(), (r.Start + r.Elapsed).Seconds(), r.Bytes, r.AvgThroughput, r.Direction)
+	}).RunStreams(conns)
 	if err != nil {
 		log.Println("throughput measurement failed:", err)
 	} else {
 		log.Println("throughput benchmark result:", prettyJSON(tpResult))
-		log.Println("throughput: ", float64(tpResult.NumMsg*tpResult.MsgSize*1000)/float64(tpResult.Elapsed.Nanoseconds()), "MB/s")
+		log.Println("throughput: ", tpResult.AvgThroughput, "MB/s")
+		if tpResult.HandshakeTime > 0 {
+			log.Println("tls handshake:", tpResult.HandshakeTime)
+		}
+		logSocket(tpResult.Socket)
+		logTCPInfo("", tpResult.TCPInfo)
+		if tpResult.ReceivedBytes > 0 {
+			log.Println("receiver throughput: ", tpResult.ReceiverThroughput, "MB/s")
+		}
+		for _, r := range tpResult.Streams {
+			log.Printf("stream %d throughput: %v MB/s", r.Stream, r.AvgThroughput)
+			logTCPInfo(fmt.Sprintf("stream %d ", r.Stream), r.TCPInfo)
+		}
+		if udp := tpResult.UDP; udp != nil {
+			log.Printf("datagrams sent/received/lost: %d/%d/%d (%.2f%%)", udp.Sent, udp.Received, udp.Lost, udp.LossPercent)
+			log.Printf("out of order: %d, duplicates: %d, jitter: %v", udp.OutOfOrder, udp.Duplicates, udp.Jitter)
+		}
+		if tpResult.Direction == benchmate.DirectionBidir {
+			log.Println("upstream throughput: ", tpResult.Upstream.AvgThroughput, "MB/s")
+			log.Println("downstream throughput: ", tpResult.Downstream.AvgThroughput, "MB/s")
+		}
 		log.Println("throughput client done.")
 	}
 }
 
 func runLatencyClient(latOpt benchmate.Options) {
 	log.Println("running latency client with:", prettyJSON(latOpt))
-	conn, err := net.Dial(latOpt.Network, latOpt.Addr)
+	conn, err := latOpt.Dial()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer conn.Close()
-	latResult, err := latOpt.LatencyClient().Run(conn)
+	latResult, err := latOpt.LatencyClient().OnInterval(func(r benchmate.LatencyResult) {
+		log.Printf("[%7.2f-%7.2f s] %8d pings  %d lost  p50 %v  p99 %v  max %v", r.Start.Seconds(), (r.Start + r.ElapsedTime).Seconds(), r.NumMsg/2, r.Lost, r.P50, r.P99, r.Max)
+	}).Run(conn)
 	if err != nil {
 		log.Println("latency measurement failed:", err)
 	} else {
 		log.Println("latency benchmark result:", prettyJSON(latResult))
-		log.Println("average latency:", time.Duration(float64(latResult.ElapsedTime.Nanoseconds())/float64(latResult.NumMsg)))
+		if latOpt.Rate > 0 {
+			log.Printf("open-loop pings at %d/s, round-trip times measured from the scheduled send time", latOpt.Rate)
+		} else {
+			log.Println("average latency:", time.Duration(float64(latResult.ElapsedTime.Nanoseconds())/float64(latResult.NumMsg)))
+		}
+		log.Printf("round-trip min/mean/max/stddev: %v/%v/%v/%v", latResult.Min, latResult.Mean, latResult.Max, latResult.StdDev)
+		log.Printf("round-trip p50/p90/p99/p99.9: %v/%v/%v/%v", latResult.P50, latResult.P90, latResult.P99, latResult.P999)
+		log.Printf("jitter: %v, mean delay variation: %v", latResult.Jitter, latResult.MeanDelayVariation)
+		if latResult.HandshakeTime > 0 {
+			log.Println("tls handshake:", latResult.HandshakeTime)
+		}
+		logSocket(latResult.Socket)
+		logTCPInfo("", latResult.TCPInfo)
+		if latResult.Lost > 0 {
+			log.Printf("lost pings: %d of %d", latResult.Lost, latResult.Lost+latResult.NumMsg/2)
+		}
 		log.Println("latency client done.")
 	}
 }
 
-func runThroughputServer(tpOpt benchmate.Options) {
+func runTransactionClient(rrOpt benchmate.Options) {
+	log.Println("running transaction client with:", prettyJSON(rrOpt))
+	c := rrOpt.TransactionClient().OnInterval(func(r benchmate.TransactionResult) {
+		log.Printf("[%7.2f-%7.2f s] %8d transactions %10.0f/s  p50 %v  p99 %v  max %v", r.Start.Seconds(), (r.Start + r.Elapsed).Seconds(), r.Transactions, r.Rate, r.P50, r.P99, r.Max)
+	})
+	var result *benchmate.TransactionResult
+	var err error
+	if rrOpt.Connect {
+		result, err = c.RunConnect(rrOpt.DialContext)
+	} else {
+		var conn net.Conn
+		conn, err = rrOpt.Dial()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		result, err = c.Run(conn)
+	}
+	if err != nil {
+		log.Println("transaction measurement failed:", err)
+		return
+	}
+	log.Println("transaction benchmark result:", prettyJSON(result))
+	log.Printf("transactions: %d in %v, %.0f/s", result.Transactions, result.Elapsed, result.Rate)
+	log.Printf("transaction time min/mean/max/stddev: %v/%v/%v/%v", result.Min, result.Mean, result.Max, result.StdDev)
+	log.Printf("transaction time p50/p90/p99/p99.9: %v/%v/%v/%v", result.P50, result.P90, result.P99, result.P999)
+	if result.HandshakeTime > 0 {
+		log.Println("tls handshake:", result.HandshakeTime)
+	}
+	logSocket(result.Socket)
+	log.Println("transaction client done.")
+}
 
-	l, err := net.Listen(tpOpt.Network, tpOpt.Addr)
+func runConnectClient(cOpt benchmate.Options) {
+	log.Println("running connect client with:", prettyJSON(cOpt))
+	result, err := cOpt.ConnectClient().OnInterval(func(r benchmate.ConnectResult) {
+		log.Printf("[%7.2f-%7.2f s] %8d connections %10.0f/s %6d failed  p50 %v  p99 %v  max %v", r.Start.Seconds(), (r.Start + r.Elapsed).Seconds(), r.Connections, r.Rate, r.Failed, r.P50, r.P99, r.Max)
+	}).Run(cOpt.DialContext)
 	if err != nil {
-		log.Println("throughput server failed:", err)
+		log.Println("connect measurement failed:", err)
 		return
 	}
-	defer l.Close()
+	log.Println("connect benchmark result:", prettyJSON(result))
+	log.Printf("connections: %d in %v, %.0f/s, %d failed", result.Connections, result.Elapsed, result.Rate, result.Failed)
+	log.Printf("connect time min/mean/max/stddev: %v/%v/%v/%v", result.Min, result.Mean, result.Max, result.StdDev)
+	log.Printf("connect time p50/p90/p99/p99.9: %v/%v/%v/%v", result.P50, result.P90, result.P99, result.P999)
+	if result.HandshakeTime > 0 {
+		log.Println("mean tls handshake:", result.HandshakeTime)
+	}
+	log.Println("connect client done.")
+}
 
-	log.Println("running throughput server with:", prettyJSON(tpOpt))
+func runLoadedLatencyClient(latOpt, tpOpt benchmate.Options) {
+	log.Println("running latency client with:", prettyJSON(latOpt))
+	log.Println("loading the network with throughput client with:", prettyJSON(tpOpt))
+	conn, err := latOpt.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	var loadConns []net.Conn
+	for i := 0; i == 0 || i < tpOpt.Parallel; i++ {
+		conn, err := tpOpt.Dial()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		loadConns = append(loadConns, conn)
+	}
 
-	err = tpOpt.ThroughputServer().Run(l)
+	result, err := benchmate.NewLoadedLatencyClient(latOpt.LatencyClient(), tpOpt.ThroughputClient()).Run(conn, loadConns)
 	if err != nil {
-		if err == io.EOF {
-			log.Println("throughput server done.")
-		} else {
-			log.Println("throughput server failed:", err)
+		log.Println("latency measurement under load failed:", err)
+		return
+	}
+	log.Println("latency under load result:", prettyJSON(result))
+	log.Printf("idle round-trip p50/p90/p99/p99.9: %v/%v/%v/%v", result.Idle.P50, result.Idle.P90, result.Idle.P99, result.Idle.P999)
+	log.Printf("loaded round-trip p50/p90/p99/p99.9: %v/%v/%v/%v", result.Loaded.P50, result.Loaded.P90, result.Loaded.P99, result.Loaded.P999)
+	log.Println("throughput of the load: ", result.Throughput.AvgThroughput, "MB/s")
+	log.Println("queueing delay under load:", result.QueueingDelay)
+	if result.HandshakeTime > 0 {
+		log.Println("tls handshake:", result.HandshakeTime)
+	}
+	log.Println("latency under load client done.")
+}
+
+func runSweepClient(latOpt, tpOpt benchmate.Options, minSize, maxSize int, lat, tp bool) {
+	s := benchmate.NewSweepClient(minSize, maxSize)
+	if lat {
+		log.Println("running latency tests with:", prettyJSON(latOpt))
+		s = s.WithLatency(latOpt.LatencyClient())
+	}
+	if tp {
+		log.Println("running throughput tests with:", prettyJSON(tpOpt))
+		s = s.WithThroughput(tpOpt.ThroughputClient())
+	}
+	opt := latOpt
+	if !lat {
+		opt = tpOpt
+	}
+
+	log.Printf("%10s %12s %12s %12s %12s", "msgSize", "p50", "p99", "max", "MB/s")
+	_, err := s.OnResult(func(r benchmate.SweepResult) {
+		var p50, p99, max, throughput string
+		if r.Latency != nil {
+			p50, p99, max = r.Latency.P50.String(), r.Latency.P99.String(), r.Latency.Max.String()
+		}
+		if r.Throughput != nil {
+			throughput = fmt.Sprintf("%.2f", r.Throughput.AvgThroughput)
+		}
+		log.Printf("%10d %12s %12s %12s %12s", r.MsgSize, p50, p99, max, throughput)
+	}).Run(opt.DialContext)
+	if err != nil {
+		log.Println("sweep failed:", err)
+		return
+	}
+	log.Println("sweep client done.")
+}
+
+// runServers runs a server for every address of the options. Options with
+// the same address share a server, which serves one test per options
+// unless it runs in daemon mode.
+func runServers(opts []benchmate.Options, daemon bool) {
+	tests := make(map[string]int)
+	var servers []benchmate.Options
+	for _, o := range opts {
+		key := o.Network + " " + o.Addr
+		if tests[key] == 0 {
+			servers = append(servers, o)
 		}
+		tests[key]++
 	}
 
+	var wg sync.WaitGroup
+	for _, o := range servers {
+		wg.Add(1)
+		go func(o benchmate.Options) {
+			defer wg.Done()
+			runServer(o, daemon, tests[o.Network+" "+o.Addr])
+		}(o)
+	}
+	wg.Wait()
 }
 
-func runLatencyServer(latOpt benchmate.Options) {
-	l, err := net.Listen(latOpt.Network, latOpt.Addr)
+// runServer runs a server for latency and throughput tests. It serves the
+// given number of tests or any number of them in daemon mode.
+func runServer(opt benchmate.Options, daemon bool, tests int) {
+	l, err := opt.Listen()
 	if err != nil {
-		log.Println("latency server failed:", err)
+		log.Println("server failed:", err)
 		return
 	}
 	defer l.Close()
 
-	log.Println("running latency server with:", prettyJSON(latOpt))
+	if daemon {
+		log.Println("running server in daemon mode with:", prettyJSON(opt))
+		closeOnSignal(l)
+		err = opt.Server().Serve(l)
+		if err != nil {
+			log.Println("server failed:", err)
+		} else {
+			log.Println("server done.")
+		}
+		return
+	}
 
-	err = latOpt.LatencyServer().Run(l)
-	if err != nil {
-		log.Println("latency server:", err)
-	} else {
-		log.Println("latency server done.")
+	log.Println("running server with:", prettyJSON(opt))
+
+	for i := 0; i < tests; i++ {
+		result, err := opt.Server().Run(l)
+		if err != nil {
+			log.Println("server failed:", err)
+			continue
+		}
+		if result.Throughput != nil {
+			log.Println("throughput server result:", prettyJSON(result.Throughput))
+		}
+		log.Printf("%s test done.", result.Type)
+	}
+}
+
+// isDatagram reports whether network is a datagram network.
+func isDatagram(network string) bool {
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	default:
+		return false
 	}
 }
+
+// closeOnSignal closes the listener when the process is interrupted or
+// terminated, which lets servers in daemon mode finish running sessions
+// and return.
+func closeOnSignal(l net.Listener) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		log.Println("shutting down", l.Addr())
+		_ = l.Close()
+	}()
+}
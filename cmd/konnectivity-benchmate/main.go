@@ -27,19 +27,22 @@ limitations under the License.
 //	Usage of ./konnectivity-benchmate:
 //	-node-ip string
 //		ip of node where benchmate server is running (default "127.0.0.1")
+//	-port int
+//		port of benchmate server, it serves both latency and throughput tests (default 13500)
 //	-proxy-uds string
 //		uds socket of konnectivity-proxy (default "/etc/kubernetes/konnectivity-server/konnectivity-server.socket")
+//	-sweep
+//		run latency and throughput tests with message sizes from 64B to 4MiB in powers of two, the server must run in daemon mode
+//	-cps
+//		measure how long setting up a tunnel and dialing through it takes and how many connections per second get through, the server must run in daemon mode
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
-	"net"
 
 	"github.com/kubermatic/benchmate"
-	"google.golang.org/grpc"
-	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/client"
+	"github.com/kubermatic/benchmate/konnectivity"
 )
 
 func main() {
@@ -49,42 +52,75 @@ func main() {
 	var nodeIP string
 	flag.StringVar(&nodeIP, "node-ip", "127.0.0.1", "ip of node where benchmate server is running")
 
+	var port int
+	flag.IntVar(&port, "port", 13500, "port of benchmate server, it serves both latency and throughput tests")
+
+	var sweep bool
+	flag.BoolVar(&sweep, "sweep", false, "run latency and throughput tests with message sizes from 64B to 4MiB in powers of two, the server must run in daemon mode")
+
+	var cps bool
+	flag.BoolVar(&cps, "cps", false, "measure how long setting up a tunnel and dialing through it takes and how many connections per second get through, the server must run in daemon mode")
+
 	flag.Parse()
 
-	dialOption := grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
-		c, err := net.DialTimeout("unix", proxyUDSName, 0)
+	// every connection goes through a tunnel of its own
+	benchmate.RegisterTransport(konnectivity.Network, konnectivity.NewTransport(proxyUDSName))
+	requestAddress := fmt.Sprintf("%s:%d", nodeIP, port)
+	overTunnel := func(o benchmate.Options) benchmate.Options {
+		o.Network, o.Addr = konnectivity.Network, requestAddress
+		return o
+	}
+
+	if cps {
+		o := overTunnel(benchmate.DefaultConnectOptions())
+		result, err := o.ConnectClient().Run(o.DialContext)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create connection to %s: %+v", proxyUDSName, err)
+			panic(err)
 		}
-		return c, err
-	})
+		fmt.Printf("%d connections %.0f/s %d failed p50 %v p99 %v\n", result.Connections, result.Rate, result.Failed, result.P50, result.P99)
+		return
+	}
 
-	ctx := context.Background()
-	tunnel, err := client.CreateSingleUseGrpcTunnel(ctx, proxyUDSName, dialOption, grpc.WithInsecure(), grpc.WithUserAgent("o.userAgent"))
-	if err != nil {
-		panic(err)
+	if sweep {
+		lo := overTunnel(benchmate.DefaultLatencyOptions())
+		lo.NumMsg = 100
+		to := overTunnel(benchmate.DefaultThroughputOptions())
+		to.Duration = 1000
+		s := benchmate.NewSweepClient(benchmate.DefaultSweepMinSize, benchmate.DefaultSweepMaxSize).
+			WithLatency(lo.LatencyClient()).
+			WithThroughput(to.ThroughputClient())
+		results, err := s.Run(lo.DialContext)
+		for _, r := range results {
+			fmt.Printf("%10d p50 %v p99 %v %.2f MB/s\n", r.MsgSize, r.Latency.P50, r.Latency.P99, r.Throughput.AvgThroughput)
+		}
+		if err != nil {
+			panic(err)
+		}
+		return
 	}
 
-	requestAddress := fmt.Sprintf("%s:%d", nodeIP, 13500)
-	proxyConn, err := tunnel.DialContext(ctx, "tcp", requestAddress)
+	to := overTunnel(benchmate.DefaultThroughputOptions())
+	proxyConn, err := to.Dial()
 	if err != nil {
 		panic(err)
 	}
 
-	tpResult, err := benchmate.DefaultThroughputOptions().ThroughputClient().Run(proxyConn)
+	tpResult, err := to.ThroughputClient().Run(proxyConn)
+	proxyConn.Close()
 	if err != nil {
 		panic(err)
 	}
 
 	fmt.Println(tpResult)
 
-	requestAddress = fmt.Sprintf("%s:%d", nodeIP, 13501)
-	proxyConn, err = tunnel.DialContext(ctx, "tcp", requestAddress)
+	lo := overTunnel(benchmate.DefaultLatencyOptions())
+	proxyConn, err = lo.Dial()
 	if err != nil {
 		panic(err)
 	}
 
-	latResult, err := benchmate.DefaultLatencyOptions().LatencyClient().Run(proxyConn)
+	latResult, err := lo.LatencyClient().Run(proxyConn)
+	proxyConn.Close()
 	if err != nil {
 		panic(err)
 	}
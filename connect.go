@@ -0,0 +1,291 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnectResult contains the details of a connection rate run. The
+// embedded LatencyStats describe the distribution of the connect times,
+// i.e. how long dialing a connection took.
+type ConnectResult struct {
+	Connections   int           `json:"connections"`             // number of connections established and accepted by the server
+	Failed        int           `json:"failed,omitempty"`        // number of connections that could not be established
+	Elapsed       time.Duration `json:"elapsed"`                 // time elapsed in nanoseconds
+	Rate          float64       `json:"rate"`                    // connections per second
+	Parallel      int           `json:"parallel"`                // number of connections dialed at the same time
+	HandshakeTime time.Duration `json:"handshakeTime,omitempty"` // mean time the TLS handshake took, it does not count towards the connect time
+	LatencyStats
+	Samples []time.Duration `json:"-"` // connect time of every connection in the order they were established
+
+	Start     time.Duration   `json:"start,omitempty"`     // offset of an interval from the start of the run
+	Intervals []ConnectResult `json:"intervals,omitempty"` // per interval results if interval reporting is enabled
+}
+
+// runConnectTest accepts the connect test described by desc over conn.
+// Nothing but the test handshake is exchanged over the connections of
+// connect tests.
+func runConnectTest(conn net.Conn, desc TestDescriptor) error {
+	if desc.Type != TestTypeConnect {
+		return rejectTest(conn, fmt.Errorf("unexpected test type %q, expected %q", desc.Type, TestTypeConnect))
+	}
+	return replyTest(conn, nil)
+}
+
+// handleConnect accepts the connect test described by desc over conn in
+// daemon mode. Connect tests do not wait for running sessions since they
+// are about how fast the server accepts connections, and only failures
+// are logged, a line per connection would flood the log.
+func handleConnect(conn net.Conn, desc TestDescriptor) {
+	if err := runConnectTest(conn, desc); err != nil {
+		log.Printf("connect test with %v failed: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// ConnectClient holds parameters for the client side of connection rate
+// runs.
+type ConnectClient struct {
+	numMsg     int
+	timeout    int
+	duration   int
+	parallel   int
+	interval   int
+	onInterval func(ConnectResult)
+}
+
+// NewConnectClient returns an instance of ConnectClient that establishes
+// numMsg connections one after another, unless the timeout in milliseconds
+// is reached before.
+func NewConnectClient(numMsg, timeout int) ConnectClient {
+	return ConnectClient{
+		numMsg:  numMsg,
+		timeout: timeout,
+	}
+}
+
+// OnInterval returns a copy of the client that calls fn with the results
+// of every reporting interval while Run is in progress. fn may be called
+// from different goroutines but never concurrently, and should return
+// quickly. Intervals are only reported if the client is configured with an
+// interval.
+func (c ConnectClient) OnInterval(fn func(ConnectResult)) ConnectClient {
+	c.onInterval = fn
+	return c
+}
+
+// Run measures how long establishing a connection with dial takes and how
+// many connections per second the server accepts. Every connection
+// completes the test handshake, so it counts once the server accepted it,
+// and is closed right after. Only dialing counts towards the connect time,
+// which is the TCP handshake over tcp and connecting the socket over unix.
+// The TLS handshake of connections returned by Options.WrapConn is reported
+// separately. The rate includes all handshakes and closing the connection.
+//
+// Connections that cannot be established, e.g. because the SYN backlog of
+// the server or a conntrack table is full, are counted as failed and the
+// run goes on. Connections are dialed one after another unless the client
+// is configured with parallel streams, and if the client is configured with
+// a duration, they are dialed until the duration has passed instead of
+// dialing the configured number of them.
+//
+// The server has to serve the connections one after another or
+// concurrently, e.g. a Server in daemon mode. Datagram networks are not
+// supported since they have no connection setup to measure.
+func (c ConnectClient) Run(dial DialFunc) (*ConnectResult, error) {
+	return c.RunContext(context.Background(), dial)
+}
+
+// RunContext is like Run but aborts the run once ctx is done. The result of
+// the connections established until then is returned along with an error
+// wrapping the context error.
+func (c ConnectClient) RunContext(ctx context.Context, dial DialFunc) (*ConnectResult, error) {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	stopTime := start.Add(time.Duration(c.timeout) * time.Millisecond)
+	if c.duration > 0 {
+		stopTime = start.Add(time.Duration(c.duration) * time.Millisecond)
+	}
+
+	var (
+		mu         sync.Mutex
+		meter      = c.newMeter(start)
+		handshakes time.Duration
+		dialed     int
+		done       bool
+		lastErr    error
+		runErr     error
+		wg         sync.WaitGroup
+	)
+	// next reserves the next connection of the run, it returns false once
+	// the run is over
+	next := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if done || (c.duration <= 0 && dialed >= c.numMsg) {
+			return false
+		}
+		dialed++
+		return true
+	}
+	for i := 0; i < c.streams(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next() {
+				rtt, hs, err := c.connect(runCtx, dial)
+
+				mu.Lock()
+				now := time.Now()
+				switch {
+				case err == nil:
+					meter.add(rtt, now)
+					handshakes += hs
+				case errors.Is(err, errConnectFailed):
+					meter.addLost(now)
+					lastErr = err
+				case runErr == nil:
+					runErr = err
+					done = true
+					cancel()
+				}
+				if now.After(stopTime) {
+					done = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := c.result(meter.result(time.Now()))
+	if result.Connections > 0 {
+		result.HandshakeTime = handshakes / time.Duration(result.Connections)
+	}
+	if runErr != nil {
+		if ctxErr := interrupted(ctx, runErr); ctxErr != nil {
+			return result, ctxErr
+		}
+		return nil, runErr
+	}
+	if result.Connections == 0 && lastErr != nil {
+		return nil, fmt.Errorf("all %d connections failed, last error: %w", result.Failed, lastErr)
+	}
+	return result, nil
+}
+
+// errConnectFailed marks connections that could not be established.
+var errConnectFailed = errors.New("connect failed")
+
+// connect establishes a single connection, completes the test handshake
+// and closes the connection. It returns how long dialing and the TLS
+// handshake of TLS connections took. Errors of dial and the handshakes
+// wrap errConnectFailed unless ctx is done, e.g. if the server does not
+// keep up with accepting connections.
+func (c ConnectClient) connect(ctx context.Context, dial DialFunc) (time.Duration, time.Duration, error) {
+	// a connection must not hold up the run forever if the server does
+	// not answer at all
+	connCtx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	failed := func(err error) error {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errConnectFailed, err)
+	}
+
+	start := time.Now()
+	conn, err := dial(connCtx)
+	if err != nil {
+		return 0, 0, failed(err)
+	}
+	rtt := time.Since(start)
+	defer conn.Close()
+
+	if isDatagram(conn) {
+		return 0, 0, errors.New("connect tests need a stream connection")
+	}
+	hs, err := handshakeTLS(connCtx, conn)
+	if err == nil {
+		err = handshake(connCtx, conn, c.descriptor())
+	}
+	if err != nil {
+		return 0, 0, failed(err)
+	}
+	return rtt, hs, nil
+}
+
+// streams returns the number of connections dialed at the same time.
+func (c ConnectClient) streams() int {
+	if c.parallel < 1 {
+		return 1
+	}
+	return c.parallel
+}
+
+// descriptor returns the test descriptor sent over every connection.
+func (c ConnectClient) descriptor() TestDescriptor {
+	return TestDescriptor{
+		Type:     TestTypeConnect,
+		NumMsg:   c.numMsg,
+		Timeout:  c.timeout,
+		Duration: c.duration,
+		Streams:  c.streams(),
+	}
+}
+
+// newMeter returns a meter for the connect times of a run starting at
+// start. Failed connections are recorded as lost.
+func (c ConnectClient) newMeter(start time.Time) *latencyMeter {
+	var report func(LatencyResult)
+	if c.onInterval != nil {
+		report = func(r LatencyResult) {
+			c.onInterval(*c.result(&r))
+		}
+	}
+	return newLatencyMeter(c.numMsg, c.interval, report, start)
+}
+
+// result returns the ConnectResult of the connect times collected in r.
+func (c ConnectClient) result(r *LatencyResult) *ConnectResult {
+	result := &ConnectResult{
+		// a latency result counts both messages of a round trip
+		Connections:  r.NumMsg / 2,
+		Failed:       r.Lost,
+		Elapsed:      r.ElapsedTime,
+		Parallel:     c.streams(),
+		LatencyStats: r.LatencyStats,
+		Samples:      r.Samples,
+		Start:        r.Start,
+	}
+	if r.ElapsedTime > 0 {
+		result.Rate = float64(result.Connections) / r.ElapsedTime.Seconds()
+	}
+	for i := range r.Intervals {
+		result.Intervals = append(result.Intervals, *c.result(&r.Intervals[i]))
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnect(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultConnectOptions()
+	o.NumMsg = 200
+	o.Parallel = 4
+	o.Interval = 10
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		_ = o.Server().Serve(l)
+	}()
+
+	var dials int32
+	intervals := 0
+	result, err := o.ConnectClient().OnInterval(func(r ConnectResult) {
+		intervals += r.Connections
+	}).Run(func(ctx context.Context) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		var d net.Dialer
+		return d.DialContext(ctx, o.Network, o.Addr)
+	})
+	if err != nil {
+		t.Fatalf("Error running connect test: %v", err)
+	}
+
+	if result.Connections != o.NumMsg || int(dials) != o.NumMsg || result.Failed != 0 {
+		t.Errorf("expected %d connections, got %d of %d dialed and %d failed", o.NumMsg, result.Connections, dials, result.Failed)
+	}
+	if result.Parallel != o.Parallel || result.Rate <= 0 || result.Min <= 0 || result.P99 < result.P50 {
+		t.Errorf("expected connection rate and connect times, got %+v", result)
+	}
+	if intervals != result.Connections {
+		t.Errorf("expected intervals to add up to %d connections, got %d", result.Connections, intervals)
+	}
+	t.Logf("%.0f connections/s, p50 %v", result.Rate, result.P50)
+}
+
+func TestConnectFailed(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultConnectOptions()
+	o.NumMsg = 50
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		_ = o.Server().Serve(l)
+	}()
+
+	// every 5th connection fails
+	n := 0
+	result, err := o.ConnectClient().Run(func(ctx context.Context) (net.Conn, error) {
+		n++
+		if n%5 == 0 {
+			return nil, errors.New("connection refused")
+		}
+		return net.Dial(o.Network, o.Addr)
+	})
+	if err != nil {
+		t.Fatalf("Error running connect test: %v", err)
+	}
+	if result.Connections != 40 || result.Failed != 10 {
+		t.Errorf("expected 40 connections and 10 failed, got %d and %d", result.Connections, result.Failed)
+	}
+
+	// every 5th connection is dropped before the handshake is done
+	n = 0
+	result, err = o.ConnectClient().Run(func(ctx context.Context) (net.Conn, error) {
+		n++
+		if n%5 == 0 {
+			conn, peer := net.Pipe()
+			peer.Close()
+			return conn, nil
+		}
+		return net.Dial(o.Network, o.Addr)
+	})
+	if err != nil {
+		t.Fatalf("Error running connect test: %v", err)
+	}
+	if result.Connections != 40 || result.Failed != 10 {
+		t.Errorf("expected 40 connections and 10 failed, got %d and %d", result.Connections, result.Failed)
+	}
+
+	// nothing listens on the port any more
+	l.Close()
+	if _, err := o.ConnectClient().Run(func(ctx context.Context) (net.Conn, error) {
+		return net.Dial(o.Network, o.Addr)
+	}); err == nil {
+		t.Error("expected run without any connection to fail")
+	}
+}
+
+func TestConnectServerRun(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultConnectOptions()
+	o.NumMsg = 1
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	results := make(chan *ServerResult, 1)
+	go func() {
+		result, err := NewServer().Run(l)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result
+	}()
+
+	result, err := o.ConnectClient().Run(func(ctx context.Context) (net.Conn, error) {
+		return net.Dial(o.Network, o.Addr)
+	})
+	if err != nil {
+		t.Fatalf("Error running connect test: %v", err)
+	}
+	if result.Connections != 1 {
+		t.Errorf("expected a connection, got %d", result.Connections)
+	}
+	if r := <-results; r == nil || r.Type != TestTypeConnect {
+		t.Errorf("expected connect test, got %+v", r)
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past that aborts blocked I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// deadlines sets the deadlines of a connection so that its I/O is aborted
+// when a context is done. Not every connection supports deadlines, e.g.
+// konnectivity tunnels don't, so I/O on them cannot be aborted.
+type deadlines struct {
+	ctx  context.Context
+	conn net.Conn
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+// watch sets the deadline of ctx on conn and aborts blocked I/O on conn
+// as soon as ctx is done. Call stop once I/O on conn is done.
+func watch(ctx context.Context, conn net.Conn) *deadlines {
+	d := &deadlines{
+		ctx:  ctx,
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	d.set(conn.SetDeadline, time.Time{})
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				_ = conn.SetDeadline(aLongTimeAgo)
+			case <-d.done:
+			}
+		}()
+	}
+
+	return d
+}
+
+// setRead sets the read deadline to t or the deadline of the context,
+// whichever is earlier. Zero t means no deadline.
+func (d *deadlines) setRead(t time.Time) {
+	d.set(d.conn.SetReadDeadline, t)
+}
+
+// setWrite sets the write deadline to t or the deadline of the context,
+// whichever is earlier. Zero t means no deadline.
+func (d *deadlines) setWrite(t time.Time) {
+	d.set(d.conn.SetWriteDeadline, t)
+}
+
+func (d *deadlines) set(setDeadline func(time.Time) error, t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.ctx.Err() != nil {
+		t = aLongTimeAgo
+	} else if dl, ok := d.ctx.Deadline(); ok && (t.IsZero() || dl.Before(t)) {
+		t = dl
+	}
+	_ = setDeadline(t)
+}
+
+// stop stops watching the context and clears the deadlines of the connection.
+func (d *deadlines) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	close(d.done)
+	_ = d.conn.SetDeadline(time.Time{})
+}
+
+// watchListener aborts a blocked Accept on l as soon as ctx is done.
+// Listeners that do not support deadlines, or fail to set one, are closed
+// instead. Call the returned function once done accepting.
+func watchListener(ctx context.Context, l net.Listener) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	dl, ok := l.(interface{ SetDeadline(time.Time) error })
+	var mu sync.Mutex
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			defer mu.Unlock()
+			if !ok || dl.SetDeadline(aLongTimeAgo) != nil {
+				_ = l.Close()
+			}
+		case <-done:
+		}
+	}()
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		close(done)
+		if ok {
+			_ = dl.SetDeadline(time.Time{})
+		}
+	}
+}
+
+// interrupted returns an error wrapping the context error if err was caused
+// by ctx being done. It returns nil otherwise.
+func interrupted(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("interrupted: %w", ctxErr)
+	}
+	// the deadline of the connection may expire a moment before the one of
+	// the context
+	if dl, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(dl) {
+		return fmt.Errorf("interrupted: %w", context.DeadlineExceeded)
+	}
+	return nil
+}
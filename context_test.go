@@ -0,0 +1,137 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// hungPeer accepts a connection and the test sent over it but then never
+// reads from or writes to it.
+func hungPeer(t *testing.T) (net.Conn, func()) {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", randPort()))
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			accepted <- conn
+			if _, err := readTest(context.Background(), conn); err == nil {
+				_ = replyTest(conn, nil)
+			}
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	peer := <-accepted
+
+	return conn, func() {
+		conn.Close()
+		peer.Close()
+		l.Close()
+	}
+}
+
+func TestLatencyClientContext(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	conn, cleanup := hungPeer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	result, err := DefaultLatencyOptions().LatencyClient().RunContext(ctx, conn)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if result == nil || result.NumMsg != 0 {
+		t.Errorf("expected empty partial result, got %+v", result)
+	}
+}
+
+func TestThroughputClientContext(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	conn, cleanup := hungPeer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	o := DefaultThroughputOptions()
+	o.MsgSize = 1024
+	start := time.Now()
+	result, err := o.ThroughputClient().RunContext(ctx, conn)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("client was not interrupted in time")
+	}
+	if result == nil || result.Bytes == 0 {
+		t.Errorf("expected partial result with the bytes buffered by the kernel, got %+v", result)
+	}
+}
+
+func TestServerContext(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", randPort()))
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	_, err = DefaultThroughputOptions().ThroughputServer().RunContext(ctx, l)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("throughput server: expected context.Canceled, got %v", err)
+	}
+
+	err = DefaultLatencyOptions().LatencyServer().ServeContext(ctx, l)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("latency server: expected context.Canceled, got %v", err)
+	}
+
+	// the listener is still usable
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("expected listener to accept connections, got %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// ProtocolVersion is the version of the control protocol. Servers reject
+// clients speaking a different version.
+const ProtocolVersion = 1
+
+// Test types of a TestDescriptor.
+const (
+	TestTypeLatency     = "latency"
+	TestTypeThroughput  = "throughput"
+	TestTypeTransaction = "transaction"
+	TestTypeConnect     = "connect"
+)
+
+// controlMagic starts every connection so servers can tell benchmate
+// clients from anything else connecting to them.
+const controlMagic = "BMTE"
+
+// maxFrameSize limits the size of control messages.
+const maxFrameSize = 64 * 1024
+
+// Limits of the tests servers accept, so a test descriptor cannot make a
+// server allocate arbitrary amounts of memory.
+const (
+	maxMsgSize = 64 * 1024 * 1024 // largest message size in bytes, also of all parallel streams together
+	maxStreams = 128              // most parallel streams of a test, also of the load of latency tests
+)
+
+// handshakeTimeout limits how long servers wait for the test descriptor.
+const handshakeTimeout = 10 * time.Second
+
+// TestDescriptor describes the test a client wants to run. Clients send it
+// at the start of every connection and servers configure themselves from
+// it, so the server side needs no options that match the client's.
+type TestDescriptor struct {
+	Version     int    `json:"version"`               // protocol version, see ProtocolVersion
+	Type        string `json:"type"`                  // TestTypeLatency, TestTypeThroughput, TestTypeTransaction or TestTypeConnect
+	MsgSize     int    `json:"msgSize"`               // size of messages in bytes, the request size of transaction tests
+	RespSize    int    `json:"respSize,omitempty"`    // response size of transaction tests in bytes
+	NumMsg      int    `json:"numMsg"`                // number of messages to exchange
+	Timeout     int    `json:"timeout"`               // in milliseconds
+	PingTimeout int    `json:"pingTimeout,omitempty"` // timeout of a ping of latency runs over datagram sockets in milliseconds
+	Duration    int    `json:"duration,omitempty"`    // duration of throughput runs in milliseconds
+	Direction   string `json:"direction,omitempty"`   // direction of throughput runs
+	Bitrate     int64  `json:"bitrate,omitempty"`     // target bitrate of throughput runs in bits per second
+	Streams     int    `json:"streams,omitempty"`     // number of parallel streams of throughput runs
+	Stream      int    `json:"stream,omitempty"`      // index of the stream, starting at 1
+	Cookie      string `json:"cookie,omitempty"`      // identifies the streams of one test
+	Load        int    `json:"load,omitempty"`        // number of throughput streams loading the network during a latency test
+}
+
+// controlReply is the answer of the server to a TestDescriptor.
+type controlReply struct {
+	Error string `json:"error,omitempty"` // reason for rejecting the test
+}
+
+// newCookie returns a random identifier for the streams of a test.
+func newCookie() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// handshake sends the test descriptor over conn and waits for the server
+// to accept the test. Over datagram sockets the descriptor and the reply
+// are single datagrams, which are not sent again if they get lost.
+func handshake(ctx context.Context, conn net.Conn, desc TestDescriptor) error {
+	return handshakeData(ctx, conn, desc, nil)
+}
+
+// handshakeData is like handshake but sends data along with the test
+// descriptor without waiting for the server to accept the test first.
+func handshakeData(ctx context.Context, conn net.Conn, desc TestDescriptor, data []byte) error {
+	d := watch(ctx, conn)
+	defer d.stop()
+
+	desc.Version = ProtocolVersion
+	frame, err := encodeFrame(desc)
+	if err != nil {
+		return err
+	}
+	msg := append([]byte(controlMagic), frame...)
+	if _, err := conn.Write(append(msg, data...)); err != nil {
+		return err
+	}
+
+	var r io.Reader = conn
+	if isDatagram(conn) {
+		// a datagram has to be read at once
+		r = bufio.NewReaderSize(conn, maxDatagramSize)
+		d.setRead(time.Now().Add(handshakeTimeout))
+	}
+	var reply controlReply
+	if err := readFrame(r, &reply); err != nil {
+		return fmt.Errorf("reading reply to test descriptor: %w", err)
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("server rejected test: %s", reply.Error)
+	}
+	return nil
+}
+
+// readTest reads the test descriptor sent by the client over conn.
+func readTest(ctx context.Context, conn net.Conn) (TestDescriptor, error) {
+	d := watch(ctx, conn)
+	defer d.stop()
+	d.setRead(time.Now().Add(handshakeTimeout))
+
+	var desc TestDescriptor
+	magic := make([]byte, len(controlMagic))
+	if _, err := io.ReadFull(conn, magic); err != nil {
+		return desc, err
+	}
+	if string(magic) != controlMagic {
+		return desc, errors.New("not a benchmate client")
+	}
+	if err := readFrame(conn, &desc); err != nil {
+		return desc, fmt.Errorf("reading test descriptor: %w", err)
+	}
+	if desc.Version != ProtocolVersion {
+		return desc, fmt.Errorf("unsupported protocol version %d, expected %d", desc.Version, ProtocolVersion)
+	}
+	return desc, nil
+}
+
+// replyTest accepts the test if err is nil and rejects it otherwise.
+func replyTest(conn net.Conn, err error) error {
+	var reply controlReply
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return writeFrame(conn, reply)
+}
+
+// rejectTest tells the client why its test is rejected and returns err.
+func rejectTest(conn net.Conn, err error) error {
+	_ = replyTest(conn, err)
+	return err
+}
+
+// acceptTest accepts a connection on l and reads the test descriptor sent
+// by the client over it. The connection is closed if no valid descriptor
+// is received.
+func acceptTest(ctx context.Context, l net.Listener) (net.Conn, TestDescriptor, error) {
+	conn, err := l.Accept()
+	if err != nil {
+		return nil, TestDescriptor{}, err
+	}
+	desc, err := readTest(ctx, conn)
+	if err != nil {
+		_ = rejectTest(conn, err)
+		conn.Close()
+		return nil, desc, err
+	}
+	return conn, desc, nil
+}
+
+// checkTest returns an error if desc is not a valid test of type typ.
+func checkTest(desc TestDescriptor, typ string) error {
+	if desc.Type != typ {
+		return fmt.Errorf("unexpected test type %q, expected %q", desc.Type, typ)
+	}
+	if desc.MsgSize <= 0 || desc.MsgSize > maxMsgSize {
+		return fmt.Errorf("bad message size %d, expected 1 to %d bytes", desc.MsgSize, maxMsgSize)
+	}
+	if desc.Streams > maxStreams || desc.Load > maxStreams {
+		return fmt.Errorf("too many parallel streams, at most %d are supported", maxStreams)
+	}
+	return nil
+}
+
+// writeFrame writes v as JSON preceded by its length.
+func writeFrame(w io.Writer, v interface{}) error {
+	frame, err := encodeFrame(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// encodeFrame returns v encoded as JSON preceded by its length.
+func encodeFrame(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	return frame, nil
+}
+
+// readFrame reads a frame written by writeFrame into v.
+func readFrame(r io.Reader, v interface{}) error {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrameSize {
+		return fmt.Errorf("control message too large: %d bytes", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlConfiguresServer(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	// servers run with the defaults, clients with something else
+	lo := DefaultLatencyOptions()
+	lo.Addr = fmt.Sprintf(":%d", randPort())
+	to := DefaultThroughputOptions()
+	to.Addr = fmt.Sprintf(":%d", randPort())
+
+	latListener, err := net.Listen(lo.Network, lo.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer latListener.Close()
+	tpListener, err := net.Listen(to.Network, to.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer tpListener.Close()
+
+	go func() {
+		_ = lo.LatencyServer().Serve(latListener)
+	}()
+	go func() {
+		_ = to.ThroughputServer().Serve(tpListener)
+	}()
+
+	lc := lo
+	lc.MsgSize = lo.MsgSize * 4
+	lc.NumMsg = 50
+	conn, err := net.Dial(lc.Network, lc.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	latResult, err := lc.LatencyClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+	if latResult.NumMsg != 2*lc.NumMsg {
+		t.Errorf("expected %d messages, got %d", 2*lc.NumMsg, latResult.NumMsg)
+	}
+
+	tc := to
+	tc.MsgSize = 32000
+	tc.NumMsg = 50
+	tc.Direction = DirectionReverse
+	tc.Parallel = 2
+	var conns []net.Conn
+	for i := 0; i < tc.Parallel; i++ {
+		conn, err := net.Dial(tc.Network, tc.Addr)
+		if err != nil {
+			t.Fatalf("Error making connection: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	tpResult, err := tc.ThroughputClient().RunStreams(conns)
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+	if expected := int64(tc.Parallel * tc.NumMsg * tc.MsgSize); tpResult.Bytes != expected {
+		t.Errorf("expected to receive %d bytes from the server, got %d", expected, tpResult.Bytes)
+	}
+}
+
+func TestControlRejectsTest(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultThroughputOptions()
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		_ = o.ThroughputServer().Serve(l)
+	}()
+
+	// a latency client talking to a throughput server
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	_, err = DefaultLatencyOptions().LatencyClient().Run(conn)
+	if err == nil || !strings.Contains(err.Error(), "unexpected test type") {
+		t.Errorf("expected test to be rejected because of its type, got %v", err)
+	}
+
+	// a client speaking another version of the protocol
+	conn, err = net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, controlMagic); err != nil {
+		t.Fatal(err)
+	}
+	desc := o.ThroughputClient().descriptor()
+	desc.Version = ProtocolVersion + 1
+	if err := writeFrame(conn, desc); err != nil {
+		t.Fatal(err)
+	}
+	var reply controlReply
+	if err := readFrame(conn, &reply); err != nil {
+		t.Fatalf("Error reading reply: %v", err)
+	}
+	if !strings.Contains(reply.Error, "unsupported protocol version") {
+		t.Errorf("expected test to be rejected because of its version, got %q", reply.Error)
+	}
+}
+
+func TestControlRejectsLimits(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultThroughputOptions()
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		_ = NewServer().Serve(l)
+	}()
+
+	for _, desc := range []TestDescriptor{
+		{Type: TestTypeLatency, MsgSize: 1 << 40, NumMsg: 1},
+		{Type: TestTypeLatency, MsgSize: 1024, NumMsg: 1, Load: 1e9, Cookie: "load"},
+		{Type: TestTypeThroughput, MsgSize: 1024, NumMsg: 1, Streams: 1e9, Cookie: "streams"},
+		{Type: TestTypeThroughput, MsgSize: maxMsgSize / 2, NumMsg: 1, Streams: 4, Cookie: "memory"},
+	} {
+		conn, err := net.Dial(o.Network, o.Addr)
+		if err != nil {
+			t.Fatalf("Error making connection: %v", err)
+		}
+		if err := handshake(context.Background(), conn, desc); err == nil || !strings.Contains(err.Error(), "server rejected test") {
+			t.Errorf("expected test %+v to be rejected, got %v", desc, err)
+		}
+		conn.Close()
+	}
+}
@@ -25,4 +25,9 @@ limitations under the License.
 // but for networking.
 //
 // [1] https://pkg.go.dev/net/http/pprof
+//
+// Clients and servers run over any net.Conn and net.Listener. Options.Dial
+// and Options.Listen create them with the Transport registered for the
+// network of the options, so tunnels like the one of the konnectivity
+// package work with every tool and handler once registered.
 package benchmate
@@ -3,6 +3,7 @@ module github.com/kubermatic/benchmate
 go 1.17
 
 require (
+	golang.org/x/sys v0.0.0-20211019181941-9d821ace8654
 	google.golang.org/grpc v1.41.0
 	sigs.k8s.io/apiserver-network-proxy/konnectivity-client v0.0.24
 )
@@ -11,7 +12,6 @@ require (
 	github.com/go-logr/logr v1.0.0 // indirect
 	github.com/golang/protobuf v1.4.3 // indirect
 	golang.org/x/net v0.0.0-20211015210444-4f30a5c0130f // indirect
-	golang.org/x/sys v0.0.0-20211019181941-9d821ace8654 // indirect
 	golang.org/x/text v0.3.7 // indirect
 	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
 	google.golang.org/protobuf v1.25.0 // indirect
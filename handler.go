@@ -26,7 +26,10 @@ import (
 
 // ThroughputRequest is request body for the ThroughputHandler.
 // Set Client to true for running the throuhgput client. It runs
-// server by default.
+// server by default. The server configures itself from the test sent by
+// the client. Set Direction to "reverse" on the client to let the server
+// send and the client receive. Set Parallel to run multiple streams at the
+// same time.
 type ThroughputRequest struct {
 	Options
 	Client bool `json:"client"`
@@ -73,14 +76,18 @@ func ThroughputHandler(w http.ResponseWriter, r *http.Request) {
 
 	if req.Client {
 		log.Println("running throughput client")
-		conn, err := net.Dial(req.Network, req.Addr)
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		var conns []net.Conn
+		for i := 0; i == 0 || i < req.Parallel; i++ {
+			conn, err := req.DialContext(r.Context())
+			if err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			defer conn.Close()
+			conns = append(conns, conn)
 		}
-		defer conn.Close()
-		resp, err := NewThroughputClient(req.MsgSize, req.NumMsg, req.Timeout).Run(conn)
+		resp, err := req.ThroughputClient().RunStreamsContext(r.Context(), conns)
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -99,7 +106,7 @@ func ThroughputHandler(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		l, err := net.Listen(req.Network, req.Addr)
+		l, err := req.Listen()
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -107,7 +114,13 @@ func ThroughputHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		defer l.Close()
 
-		err = NewThroughputServer(req.MsgSize).Run(l)
+		result, err := req.ThroughputServer().RunContext(r.Context(), l)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = json.NewEncoder(w).Encode(result)
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -167,7 +180,7 @@ func LatencyHandler(w http.ResponseWriter, r *http.Request) {
 
 	if req.Client {
 
-		conn, err := net.Dial(req.Network, req.Addr)
+		conn, err := req.DialContext(r.Context())
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -177,7 +190,7 @@ func LatencyHandler(w http.ResponseWriter, r *http.Request) {
 
 		log.Println("running latency client")
 
-		result, err := NewLatencyClient(req.MsgSize, req.NumMsg, req.Timeout).Run(conn)
+		result, err := req.LatencyClient().RunContext(r.Context(), conn)
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -193,7 +206,7 @@ func LatencyHandler(w http.ResponseWriter, r *http.Request) {
 
 	} else {
 
-		l, err := net.Listen(req.Network, req.Addr)
+		l, err := req.Listen()
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -202,7 +215,7 @@ func LatencyHandler(w http.ResponseWriter, r *http.Request) {
 		defer l.Close()
 
 		log.Println("running latency server")
-		err = NewLatencyServer(req.MsgSize, req.NumMsg).Run(l)
+		err = req.LatencyServer().RunContext(r.Context(), l)
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
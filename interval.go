@@ -0,0 +1,205 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"time"
+)
+
+// throughputMeter accumulates the bytes transferred during a throughput run
+// and splits the run into reporting intervals.
+type throughputMeter struct {
+	msgSize   int
+	interval  time.Duration
+	report    func(ThroughputResult)
+	start     time.Time
+	winStart  time.Time
+	bytes     int64
+	winBytes  int64
+	intervals []ThroughputResult
+}
+
+// newThroughputMeter returns a throughputMeter for a run that started at
+// start. interval is in milliseconds, no intervals are tracked if it is 0.
+func newThroughputMeter(msgSize, interval int, report func(ThroughputResult), start time.Time) *throughputMeter {
+	return &throughputMeter{
+		msgSize:  msgSize,
+		interval: time.Duration(interval) * time.Millisecond,
+		report:   report,
+		start:    start,
+		winStart: start,
+	}
+}
+
+// add records n bytes transferred at time now.
+func (m *throughputMeter) add(n int, now time.Time) {
+	m.skip(now)
+	m.bytes += int64(n)
+	m.close(now)
+}
+
+// skip closes the intervals that ended before now. Intervals without any
+// bytes, e.g. during a stall, are reported with zero throughput.
+func (m *throughputMeter) skip(now time.Time) {
+	for m.interval > 0 && now.Sub(m.winStart) > m.interval {
+		m.flush(m.winStart.Add(m.interval))
+	}
+}
+
+// close closes the current interval if it ends at now.
+func (m *throughputMeter) close(now time.Time) {
+	if m.interval > 0 && now.Sub(m.winStart) == m.interval {
+		m.flush(now)
+	}
+}
+
+// flush closes the current interval at time now.
+func (m *throughputMeter) flush(now time.Time) {
+	if m.interval <= 0 || !now.After(m.winStart) {
+		return
+	}
+	r := newThroughputResult(m.msgSize, m.bytes-m.winBytes, now.Sub(m.winStart))
+	r.Start = m.winStart.Sub(m.start)
+	m.intervals = append(m.intervals, *r)
+	if m.report != nil {
+		m.report(*r)
+	}
+	m.winStart, m.winBytes = now, m.bytes
+}
+
+// result returns the result of the whole run ending at time now.
+func (m *throughputMeter) result(now time.Time) *ThroughputResult {
+	m.skip(now)
+	m.flush(now)
+	r := newThroughputResult(m.msgSize, m.bytes, now.Sub(m.start))
+	r.Intervals = m.intervals
+	return r
+}
+
+// newThroughputResult returns a ThroughputResult for bytes transferred in
+// elapsed time. Partially transferred messages are not counted in NumMsg.
+func newThroughputResult(msgSize int, bytes int64, elapsed time.Duration) *ThroughputResult {
+	r := &ThroughputResult{
+		MsgSize: msgSize,
+		Bytes:   bytes,
+		Elapsed: elapsed,
+	}
+	if msgSize > 0 {
+		r.NumMsg = int(bytes / int64(msgSize))
+	}
+	if elapsed > 0 {
+		r.AvgThroughput = float64(bytes*1000) / float64(elapsed.Nanoseconds())
+	}
+	return r
+}
+
+// latencyMeter collects the round-trip times of a latency run and splits
+// the run into reporting intervals.
+type latencyMeter struct {
+	interval  time.Duration
+	report    func(LatencyResult)
+	start     time.Time
+	winStart  time.Time
+	samples   []time.Duration
+	winFirst  int
+	lost      int
+	winLost   int
+	intervals []LatencyResult
+}
+
+// newLatencyMeter returns a latencyMeter for a run of up to numMsg pings
+// that started at start. interval is in milliseconds, no intervals are
+// tracked if it is 0.
+func newLatencyMeter(numMsg, interval int, report func(LatencyResult), start time.Time) *latencyMeter {
+	return &latencyMeter{
+		interval: time.Duration(interval) * time.Millisecond,
+		report:   report,
+		start:    start,
+		winStart: start,
+		samples:  make([]time.Duration, 0, numMsg),
+	}
+}
+
+// add records a round-trip time measured at time now.
+func (m *latencyMeter) add(rtt time.Duration, now time.Time) {
+	m.skip(now)
+	m.samples = append(m.samples, rtt)
+	m.close(now)
+}
+
+// addLost records a ping that got no reply in time at time now.
+func (m *latencyMeter) addLost(now time.Time) {
+	m.skip(now)
+	m.lost++
+	m.close(now)
+}
+
+// skip closes the intervals that ended before now. Intervals without any
+// pings, e.g. while a reply is stalled, are reported empty.
+func (m *latencyMeter) skip(now time.Time) {
+	for m.interval > 0 && now.Sub(m.winStart) > m.interval {
+		m.flush(m.winStart.Add(m.interval))
+	}
+}
+
+// close closes the current interval if it ends at now.
+func (m *latencyMeter) close(now time.Time) {
+	if m.interval > 0 && now.Sub(m.winStart) == m.interval {
+		m.flush(now)
+	}
+}
+
+// flush closes the current interval at time now.
+func (m *latencyMeter) flush(now time.Time) {
+	if m.interval <= 0 || !now.After(m.winStart) {
+		return
+	}
+	r := newLatencyResult(m.samples[m.winFirst:], now.Sub(m.winStart))
+	r.Start = m.winStart.Sub(m.start)
+	r.Samples = nil
+	r.Lost = m.lost - m.winLost
+	m.intervals = append(m.intervals, *r)
+	if m.report != nil {
+		m.report(*r)
+	}
+	m.winStart, m.winFirst, m.winLost = now, len(m.samples), m.lost
+}
+
+// result returns the result of the whole run ending at time now.
+func (m *latencyMeter) result(now time.Time) *LatencyResult {
+	m.skip(now)
+	m.flush(now)
+	r := newLatencyResult(m.samples, now.Sub(m.start))
+	r.Lost = m.lost
+	r.Intervals = m.intervals
+	return r
+}
+
+// newLatencyResult returns a LatencyResult for the round-trip time samples
+// taken in elapsed time.
+func newLatencyResult(samples []time.Duration, elapsed time.Duration) *LatencyResult {
+	r := &LatencyResult{
+		ElapsedTime:  elapsed,
+		NumMsg:       len(samples) * 2,
+		LatencyStats: NewLatencyStats(samples),
+		Samples:      samples,
+	}
+	if r.NumMsg > 0 {
+		r.AvgLatency = elapsed / time.Duration(r.NumMsg)
+	}
+	return r
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThroughputMeter(t *testing.T) {
+	start := time.Now()
+	var reported []ThroughputResult
+	m := newThroughputMeter(1000, 1000, func(r ThroughputResult) {
+		reported = append(reported, r)
+	}, start)
+
+	// 1000 bytes every 100ms for 2.5s
+	for i := 1; i <= 25; i++ {
+		m.add(1000, start.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	result := m.result(start.Add(2500 * time.Millisecond))
+
+	if len(reported) != 3 || len(result.Intervals) != 3 {
+		t.Fatalf("expected 3 intervals, got %d reported, %d in result", len(reported), len(result.Intervals))
+	}
+
+	expected := []struct {
+		start time.Duration
+		bytes int64
+	}{
+		{0, 10000},
+		{time.Second, 10000},
+		{2 * time.Second, 5000},
+	}
+	for i, e := range expected {
+		r := result.Intervals[i]
+		if r.Start != e.start || r.Bytes != e.bytes {
+			t.Errorf("interval %d: expected start %v, %d bytes, got start %v, %d bytes", i, e.start, e.bytes, r.Start, r.Bytes)
+		}
+		if r.AvgThroughput != 0.01 {
+			t.Errorf("interval %d: expected 0.01 MB/s, got %v", i, r.AvgThroughput)
+		}
+	}
+
+	if result.Bytes != 25000 || result.NumMsg != 25 || result.Elapsed != 2500*time.Millisecond {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestThroughputMeterStall(t *testing.T) {
+	start := time.Now()
+	var reported []ThroughputResult
+	m := newThroughputMeter(1000, 1000, func(r ThroughputResult) {
+		reported = append(reported, r)
+	}, start)
+
+	// a write before and after a stall of 3s, then nothing until the end
+	m.add(1000, start.Add(500*time.Millisecond))
+	m.add(1000, start.Add(3500*time.Millisecond))
+	result := m.result(start.Add(5500 * time.Millisecond))
+
+	expected := []int64{1000, 0, 0, 1000, 0, 0}
+	if len(reported) != len(expected) || len(result.Intervals) != len(expected) {
+		t.Fatalf("expected %d intervals, got %d reported, %d in result", len(expected), len(reported), len(result.Intervals))
+	}
+	for i, bytes := range expected {
+		r := result.Intervals[i]
+		if r.Start != time.Duration(i)*time.Second || r.Bytes != bytes {
+			t.Errorf("interval %d: expected start %v, %d bytes, got start %v, %d bytes", i, time.Duration(i)*time.Second, bytes, r.Start, r.Bytes)
+		}
+	}
+	if r := result.Intervals[len(expected)-1]; r.Elapsed != 500*time.Millisecond {
+		t.Errorf("expected last interval of 500ms, got %v", r.Elapsed)
+	}
+}
+
+func TestLatencyMeter(t *testing.T) {
+	start := time.Now()
+	var reported []LatencyResult
+	m := newLatencyMeter(20, 500, func(r LatencyResult) {
+		reported = append(reported, r)
+	}, start)
+
+	// a ping every 100ms, round-trip time grows by 1ms each ping
+	for i := 1; i <= 10; i++ {
+		m.add(time.Duration(i)*time.Millisecond, start.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	result := m.result(start.Add(time.Second))
+
+	if len(reported) != 2 || len(result.Intervals) != 2 {
+		t.Fatalf("expected 2 intervals, got %d reported, %d in result", len(reported), len(result.Intervals))
+	}
+
+	if r := result.Intervals[0]; r.NumMsg != 10 || r.Max != 5*time.Millisecond || r.Samples != nil {
+		t.Errorf("interval 0: unexpected result: %+v", r)
+	}
+	if r := result.Intervals[1]; r.Start != 500*time.Millisecond || r.Min != 6*time.Millisecond {
+		t.Errorf("interval 1: unexpected result: %+v", r)
+	}
+
+	if result.NumMsg != 20 || len(result.Samples) != 10 || result.Max != 10*time.Millisecond {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestLatencyMeterLost(t *testing.T) {
+	start := time.Now()
+	m := newLatencyMeter(10, 500, nil, start)
+
+	// every third ping is lost
+	for i := 1; i <= 9; i++ {
+		now := start.Add(time.Duration(i) * 100 * time.Millisecond)
+		if i%3 == 0 {
+			m.addLost(now)
+		} else {
+			m.add(time.Millisecond, now)
+		}
+	}
+	result := m.result(start.Add(time.Second))
+
+	if result.Lost != 3 || len(result.Samples) != 6 {
+		t.Errorf("expected 3 lost and 6 answered pings, got %d lost and %d answered", result.Lost, len(result.Samples))
+	}
+	if len(result.Intervals) != 2 || result.Intervals[0].Lost != 1 || result.Intervals[1].Lost != 2 {
+		t.Errorf("expected 1 and 2 lost pings in the intervals, got %+v", result.Intervals)
+	}
+}
+
+func TestLatencyMeterStall(t *testing.T) {
+	start := time.Now()
+	m := newLatencyMeter(10, 500, nil, start)
+
+	// the reply to the second ping is stalled for 2s
+	m.add(time.Millisecond, start.Add(100*time.Millisecond))
+	m.add(2*time.Second, start.Add(2100*time.Millisecond))
+	result := m.result(start.Add(2200 * time.Millisecond))
+
+	expected := []int{2, 0, 0, 0, 2}
+	if len(result.Intervals) != len(expected) {
+		t.Fatalf("expected %d intervals, got %+v", len(expected), result.Intervals)
+	}
+	for i, n := range expected {
+		if r := result.Intervals[i]; r.NumMsg != n || r.Start != time.Duration(i)*500*time.Millisecond {
+			t.Errorf("interval %d: expected %d messages at %v, got %+v", i, n, time.Duration(i)*500*time.Millisecond, r)
+		}
+	}
+}
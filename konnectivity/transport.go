@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package konnectivity runs benchmate tests through the tunnels of a
+// Konnectivity proxy server, see
+// https://kubernetes.io/docs/tasks/extend-kubernetes/setup-konnectivity/.
+// Register the transport with a network name of your choice and use it as
+// the network of the options, e.g.
+//
+//	benchmate.RegisterTransport(konnectivity.Network, konnectivity.NewTransport(uds))
+//	opts := benchmate.DefaultLatencyOptions()
+//	opts.Network, opts.Addr = konnectivity.Network, "10.0.0.1:13500"
+//	conn, err := opts.Dial()
+package konnectivity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/kubermatic/benchmate"
+	"google.golang.org/grpc"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/client"
+)
+
+// Network is the conventional network name of the transport.
+const Network = "konnectivity"
+
+// Transport dials TCP connections through a Konnectivity proxy server
+// listening on a unix domain socket. Every connection gets a tunnel of its
+// own, so the time to set it up counts towards the connect time of
+// connect tests. Servers cannot listen through tunnels, they run on a node
+// some Konnectivity agent reaches instead.
+type Transport struct {
+	proxyUDS string
+}
+
+// NewTransport returns a Transport through the Konnectivity proxy server
+// listening on the unix domain socket proxyUDS.
+func NewTransport(proxyUDS string) Transport {
+	return Transport{proxyUDS: proxyUDS}
+}
+
+// DialContext implements benchmate.Transport. It connects to the address
+// of the options through a new tunnel, which is torn down along with the
+// connection. Like with net.Dialer, ctx only bounds setting up the tunnel
+// and the connection, once connected it does not affect them anymore.
+func (t Transport) DialContext(ctx context.Context, o benchmate.Options) (net.Conn, error) {
+	// the tunnel lives as long as the context it was created with, which
+	// is canceled if ctx is done before the connection is established
+	tunnelCtx, cancel := context.WithCancel(context.Background())
+	connected := make(chan struct{})
+	expired := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+			expired <- true
+		case <-connected:
+			expired <- false
+		}
+	}()
+
+	conn, err := t.dial(ctx, tunnelCtx, o.Addr)
+	close(connected)
+	if <-expired && err == nil {
+		conn.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return tunnelConn{Conn: conn, cancel: cancel}, nil
+}
+
+// dial creates a tunnel living as long as tunnelCtx and connects to addr
+// through it.
+func (t Transport) dial(ctx, tunnelCtx context.Context, addr string) (net.Conn, error) {
+	dialOption := grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		var d net.Dialer
+		c, err := d.DialContext(ctx, "unix", t.proxyUDS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create connection to %s: %w", t.proxyUDS, err)
+		}
+		return c, nil
+	})
+	tunnel, err := client.CreateSingleUseGrpcTunnel(tunnelCtx, t.proxyUDS, dialOption, grpc.WithInsecure(), grpc.WithUserAgent("benchmate"))
+	if err != nil {
+		return nil, err
+	}
+	return tunnel.DialContext(ctx, "tcp", addr)
+}
+
+// tunnelConn tears down its tunnel when it is closed.
+type tunnelConn struct {
+	net.Conn
+	cancel context.CancelFunc
+}
+
+// Close closes the connection and its tunnel.
+func (c tunnelConn) Close() error {
+	defer c.cancel()
+	return c.Conn.Close()
+}
+
+// Listen implements benchmate.Transport. It always fails since servers
+// cannot listen through tunnels.
+func (Transport) Listen(o benchmate.Options) (net.Listener, error) {
+	return nil, errors.New("servers cannot listen through konnectivity tunnels")
+}
@@ -17,26 +17,54 @@ limitations under the License.
 package benchmate
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
 	"time"
 )
 
 // LatencyResult contains the details of a latency estimation run.
-// AvgLatency = NumMsg / ElapsedTime.
+// AvgLatency = NumMsg / ElapsedTime. The embedded LatencyStats describe
+// the distribution of the individual round-trip times. Over datagram
+// sockets pings can get lost, only the answered ones are counted in NumMsg
+// and the statistics.
 type LatencyResult struct {
-	ElapsedTime time.Duration `json:"elapsedTime"` // time elapsed in nanoseconds
-	NumMsg      int           `json:"numPings"`    // number of pings sent
-	AvgLatency  time.Duration `json:"avgLatency"`  // average latency in nanoseconds
+	ElapsedTime   time.Duration   `json:"elapsedTime"`             // time elapsed in nanoseconds
+	NumMsg        int             `json:"numPings"`                // number of pings sent
+	AvgLatency    time.Duration   `json:"avgLatency"`              // average latency in nanoseconds
+	Lost          int             `json:"lost,omitempty"`          // number of pings without a reply in time over datagram sockets
+	HandshakeTime time.Duration   `json:"handshakeTime,omitempty"` // time the TLS handshake took in nanoseconds, it does not count towards the pings
+	Socket        *SocketSettings `json:"socket,omitempty"`        // options in effect on the socket of the client
+	TCPInfo       *TCPInfo        `json:"tcpInfo,omitempty"`       // kernel statistics of the connection of the client at the end of the run, Linux only
+	LatencyStats
+	Samples []time.Duration `json:"-"` // round-trip time of every ping in the order they were sent
+
+	Start     time.Duration   `json:"start,omitempty"`     // offset of an interval from the start of the run
+	Intervals []LatencyResult `json:"intervals,omitempty"` // per interval results if interval reporting is enabled
 }
 
+// errLoadUnsupported rejects latency tests under load, which need a Server
+// that also runs the throughput test of the load.
+var errLoadUnsupported = errors.New("latency tests under load need a server for both test types")
+
+// errNoLoadedLatency rejects the load of a latency test that is not running
+// on the server, load streams do not wait for a session of their own.
+var errNoLoadedLatency = errors.New("no latency test under load with this cookie is running")
+
 // LatencyServer holds parameters for the server side of latency estimation.
 type LatencyServer struct {
-	msgSize int
-	numMsg  int
+	msgSize     int
+	numMsg      int
+	pingTimeout int
+	concurrent  bool
 }
 
-// NewLatencyServer creates a new instance of LatencyServer.
+// NewLatencyServer creates a new instance of LatencyServer. The message
+// size and number of messages are defaults for clients that do not send
+// them in their test descriptor.
 func NewLatencyServer(msgSize, numMsg int) LatencyServer {
 	return LatencyServer{
 		msgSize: msgSize,
@@ -44,9 +72,10 @@ func NewLatencyServer(msgSize, numMsg int) LatencyServer {
 	}
 }
 
-// Run waits to get connection from a client. It then reads the message sent
-// by the client and replies back with the same message. This allows client to
-// estimate the latency.
+// Run waits to get connection from a client. It then reads the test
+// descriptor sent by the client, configures itself from it and replies
+// back to every message sent by the client with the same message until the
+// client is done. This allows client to estimate the latency.
 //
 // It accepts a listener. The following code will run the server at port 8888.
 //
@@ -58,38 +87,179 @@ func NewLatencyServer(msgSize, numMsg int) LatencyServer {
 //	l, _ := net.Listen("unix", "/tmp/tp-srv")
 //	s.Run(l)
 func (o LatencyServer) Run(l net.Listener) error {
-	conn, err := l.Accept()
+	return o.RunContext(context.Background(), l)
+}
+
+// RunContext is like Run but stops waiting for the client and aborts
+// blocked I/O once ctx is done. It then returns an error wrapping the
+// context error.
+func (o LatencyServer) RunContext(ctx context.Context, l net.Listener) error {
+	stop := watchListener(ctx, l)
+	defer stop()
+
+	conn, desc, err := acceptTest(ctx, l)
 	if err != nil {
-		return err
+		return latencyServerOutcome(ctx, err)
 	}
 	defer conn.Close()
+	if desc.Load > 0 {
+		return rejectTest(conn, errLoadUnsupported)
+	}
+
+	return latencyServerOutcome(ctx, o.runTest(ctx, conn, desc))
+}
+
+// configure returns the server configured for the test described by desc.
+func (o LatencyServer) configure(desc TestDescriptor) (LatencyServer, error) {
+	if err := checkTest(desc, TestTypeLatency); err != nil {
+		return o, err
+	}
+
+	o.msgSize = desc.MsgSize
+	if desc.NumMsg > 0 {
+		o.numMsg = desc.NumMsg
+	}
+	o.pingTimeout = desc.PingTimeout
+	return o, nil
+}
+
+// check returns an error if the configuration cannot be used for runs
+// over conn.
+func (o LatencyServer) check(conn net.Conn) error {
+	if isDatagram(conn) && o.msgSize > maxDatagramSize {
+		return fmt.Errorf("message size %d does not fit in a datagram", o.msgSize)
+	}
+	return nil
+}
+
+// runTest runs the latency test described by desc over conn.
+func (o LatencyServer) runTest(ctx context.Context, conn net.Conn, desc TestDescriptor) error {
+	s, err := o.configure(desc)
+	if err == nil {
+		err = s.check(conn)
+	}
+	if err != nil {
+		return rejectTest(conn, err)
+	}
+	if err := replyTest(conn, nil); err != nil {
+		return err
+	}
+
+	_, err = s.echo(ctx, conn)
+	return err
+}
+
+// echo replies to the messages of the client with the same message. It
+// returns the number of messages it replied to.
+func (o LatencyServer) echo(ctx context.Context, conn net.Conn) (int, error) {
+	d := watch(ctx, conn)
+	defer d.stop()
+
+	if isDatagram(conn) {
+		return o.echoPackets(d)
+	}
+	return o.echoStream(d)
+}
+
+// latencyServerOutcome wraps the context error if ctx being done caused err.
+func latencyServerOutcome(ctx context.Context, err error) error {
+	if ctxErr := interrupted(ctx, err); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// Serve runs the server in daemon mode. It keeps accepting clients on l
+// and runs a latency session with each of them, one after another or
+// concurrently if the server is configured so. The outcome of every session
+// is logged. Serve returns nil after l is closed and all running sessions
+// are done, so closing l on a signal shuts the server down gracefully.
+func (o LatencyServer) Serve(l net.Listener) error {
+	return o.ServeContext(context.Background(), l)
+}
+
+// ServeContext is like Serve but also stops once ctx is done. Running
+// sessions are interrupted then and an error wrapping the context error
+// is returned.
+func (o LatencyServer) ServeContext(ctx context.Context, l net.Listener) error {
+	sessions := &sessions{concurrent: o.concurrent}
+	return serveTests(ctx, l, func(conn net.Conn, desc TestDescriptor) {
+		if desc.Load > 0 {
+			log.Printf("rejected latency test from %v: %v", conn.RemoteAddr(), rejectTest(conn, errLoadUnsupported))
+			return
+		}
+		o.handle(ctx, conn, desc, sessions)
+	})
+}
+
+// handle runs the latency session described by desc over conn in daemon
+// mode and logs its outcome.
+func (o LatencyServer) handle(ctx context.Context, conn net.Conn, desc TestDescriptor, sessions *sessions) {
+	s, err := o.configure(desc)
+	if err == nil {
+		err = s.check(conn)
+	}
+	if err != nil {
+		log.Printf("rejected latency test from %v: %v", conn.RemoteAddr(), rejectTest(conn, err))
+		return
+	}
+
+	sessions.begin()
+	defer sessions.end()
+	if desc.Load > 0 {
+		sessions.beginLoad(desc.Cookie)
+		defer sessions.endLoad(desc.Cookie)
+	}
+	if err := replyTest(conn, nil); err != nil {
+		log.Printf("latency session with %v failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	start := time.Now()
+	pings, err := s.echo(ctx, conn)
+	if err != nil {
+		err = latencyServerOutcome(ctx, err)
+		log.Printf("latency session with %v failed after %d pings: %v", conn.RemoteAddr(), pings, err)
+		return
+	}
+	log.Printf("latency session with %v: %d pings in %v", conn.RemoteAddr(), pings, time.Since(start))
+}
 
+// echoStream replies to the configured number of messages with the same
+// message. The client may stop early, e.g. when it times out, so EOF ends
+// the session without an error.
+func (o LatencyServer) echoStream(d *deadlines) (int, error) {
+	conn := d.conn
 	buf := make([]byte, o.msgSize)
 	for i := 0; i < o.numMsg; i++ {
-		nread, err := conn.Read(buf)
-		if err != nil {
-			return err
+		_, err := io.ReadFull(conn, buf)
+		if err == io.EOF {
+			return i, nil
 		}
-		if nread != o.msgSize {
-			return fmt.Errorf("bad nread = %d", nread)
+		if err != nil {
+			return i, err
 		}
 		nwrite, err := conn.Write(buf)
 		if err != nil {
-			return err
+			return i, err
 		}
 		if nwrite != o.msgSize {
-			return fmt.Errorf("bad nwrite = %d", nwrite)
+			return i, fmt.Errorf("bad nwrite = %d", nwrite)
 		}
 	}
 
-	return nil
+	return o.numMsg, nil
 }
 
 // LatencyClient holds parameters for the client side of latency estimation.
 type LatencyClient struct {
-	msgSize int
-	numMsg  int
-	timeout int
+	msgSize     int
+	numMsg      int
+	timeout     int
+	pingTimeout int
+	rate        int
+	interval    int
+	onInterval  func(LatencyResult)
 }
 
 // NewLatencyClient returns an instance of LatencyClient. You can
@@ -102,42 +272,126 @@ func NewLatencyClient(msgSize, numMsg, timeout int) LatencyClient {
 	}
 }
 
+// OnInterval returns a copy of the client that calls fn with the results
+// of every reporting interval while Run is in progress. fn is called from
+// the goroutine calling Run and should return quickly. Intervals are only
+// reported if the client is configured with an interval.
+func (lm LatencyClient) OnInterval(fn func(LatencyResult)) LatencyClient {
+	lm.onInterval = fn
+	return lm
+}
+
 // Run sends the messages over the connection and
 // reads the reply back from the server. After the configured number
 // of messages are exchanged or the timeout is reached, it estimates the
-// latency by total time spent / ( 2 * # messages sent).
+// latency by total time spent / ( 2 * # messages sent). The round-trip
+// time of every ping is recorded and summarized in the result.
+//
+// Before the first ping the client sends a test descriptor with its message
+// size and number of messages, so the server does not need to be configured
+// with the same values.
+//
+// Over datagram sockets, e.g. UDP or unixgram, every ping is a datagram with
+// a sequence number. A ping without a reply within the ping timeout, or
+// DefaultPingTimeout if none is configured, is counted as lost and the run
+// goes on with the next one.
+//
+// If the client is configured with a rate, it sends the pings open-loop at
+// that many pings per second instead, without waiting for the replies, and
+// matches the replies by sequence number. The round-trip time of a ping is
+// measured from the time it was due to be sent, so stalls delaying later
+// pings show up in the statistics instead of being hidden by fewer pings.
+// AvgLatency reflects the rate rather than the latency then. Pings need at
+// least 8 bytes for the sequence number over stream connections.
 func (lm LatencyClient) Run(conn net.Conn) (*LatencyResult, error) {
-	buf := make([]byte, lm.msgSize)
+	return lm.RunContext(context.Background(), conn)
+}
+
+// RunContext is like Run but sets the deadline of ctx on the connection and
+// aborts blocked I/O once ctx is done. The result of the pings exchanged
+// until then is returned along with an error wrapping the context error.
+func (lm LatencyClient) RunContext(ctx context.Context, conn net.Conn) (*LatencyResult, error) {
+	hs, err := handshakeTLS(ctx, conn)
+	if err == nil {
+		err = handshake(ctx, conn, lm.descriptor())
+	}
+	if err != nil {
+		if ctxErr := interrupted(ctx, err); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	d := watch(ctx, conn)
+	defer d.stop()
+
+	socket := socketSettings(conn)
 	t1 := time.Now()
-	stopTime := t1.Add(time.Duration(lm.timeout) * time.Millisecond)
-	pingsSent := 0
+	meter := newLatencyMeter(lm.numMsg, lm.interval, lm.onInterval, t1)
+	err = lm.ping(d, meter, t1)
+	if err == nil && isDatagram(conn) {
+		err = sendPingFins(d)
+	}
+	if err != nil {
+		ctxErr := interrupted(ctx, err)
+		if ctxErr == nil {
+			return nil, err
+		}
+		err = ctxErr
+	}
+
+	result := meter.result(time.Now())
+	result.HandshakeTime, result.Socket = hs, socket
+	result.TCPInfo = tcpInfo(conn)
+	return result, err
+}
+
+// ping exchanges the messages over the connection, see Run.
+func (lm LatencyClient) ping(d *deadlines, meter *latencyMeter, start time.Time) error {
+	switch {
+	case lm.rate > 0:
+		return lm.pingOpenLoop(d, meter, start)
+	case isDatagram(d.conn):
+		return lm.pingPackets(d, meter, start)
+	default:
+		return lm.pingStream(d, meter, start)
+	}
+}
+
+// pingStream exchanges the messages over a stream connection.
+func (lm LatencyClient) pingStream(d *deadlines, meter *latencyMeter, start time.Time) error {
+	buf := make([]byte, lm.msgSize)
+	stopTime := start.Add(time.Duration(lm.timeout) * time.Millisecond)
 	for n := 0; n < lm.numMsg; n++ {
-		nwrite, err := conn.Write(buf)
+		sent := time.Now()
+		nwrite, err := d.conn.Write(buf)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if nwrite != lm.msgSize {
-			return nil, fmt.Errorf("bad nwrite = %d", nwrite)
-		}
-		nread, err := conn.Read(buf)
-		if err != nil {
-			return nil, err
+			return fmt.Errorf("bad nwrite = %d", nwrite)
 		}
-		if nread != lm.msgSize {
-			return nil, fmt.Errorf("bad nread = %d", nread)
+		if _, err := io.ReadFull(d.conn, buf); err != nil {
+			return err
 		}
 
-		pingsSent = n + 1
-		if time.Now().After(stopTime) {
+		now := time.Now()
+		meter.add(now.Sub(sent), now)
+		if now.After(stopTime) {
 			break
 		}
 	}
-	elapsed := time.Since(t1)
-	totalpings := pingsSent * 2
 
-	return &LatencyResult{
-		ElapsedTime: elapsed,
-		NumMsg:      totalpings,
-		AvgLatency:  elapsed / time.Duration(totalpings),
-	}, nil
+	return nil
+}
+
+// descriptor returns the test descriptor sent to the server.
+func (lm LatencyClient) descriptor() TestDescriptor {
+	return TestDescriptor{
+		Type:        TestTypeLatency,
+		MsgSize:     lm.msgSize,
+		NumMsg:      lm.numMsg,
+		Timeout:     lm.timeout,
+		PingTimeout: lm.pingTimeout,
+	}
 }
@@ -39,7 +39,54 @@ func TestLatency(t *testing.T) {
 
 	fmt.Println("average latency:", time.Duration(float64(result.ElapsedTime.Nanoseconds())/float64(result.NumMsg)))
 
+	if len(result.Samples) != o.NumMsg {
+		t.Errorf("expected %d samples, got %d", o.NumMsg, len(result.Samples))
+	}
+
+	if result.Min == 0 || result.Min > result.P50 || result.P50 > result.P99 || result.P99 > result.Max {
+		t.Errorf("inconsistent latency stats: %+v", result.LatencyStats)
+	}
+
 	t.Log(result)
 
 	time.Sleep(time.Millisecond * 500)
 }
+
+func TestLatencyServe(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.NumMsg = 100
+	o.Concurrent = true
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- o.LatencyServer().Serve(l)
+	}()
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial(o.Network, o.Addr)
+		if err != nil {
+			t.Fatalf("Error making connection: %v", err)
+		}
+
+		result, err := o.LatencyClient().Run(conn)
+		if err != nil {
+			t.Errorf("Error running latency test %d: %v", i, err)
+		} else if result.NumMsg != 2*o.NumMsg {
+			t.Errorf("expected %d pings, got %d", 2*o.NumMsg, result.NumMsg)
+		}
+		conn.Close()
+	}
+
+	l.Close()
+	if err := <-done; err != nil {
+		t.Errorf("expected server to shut down cleanly, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultLoadDuration is the duration of the load of a latency test under
+// load if the throughput client is not configured with one.
+const DefaultLoadDuration = 10 * time.Second
+
+// maxLoadWarmup limits the time the load runs before the latency under
+// load is measured, so that it fills up the queues along the path first.
+const maxLoadWarmup = time.Second
+
+// LoadedLatencyResult contains the details of a latency test under load.
+// Intervals of the latency results start at the start of their phase.
+type LoadedLatencyResult struct {
+	Idle          *LatencyResult    `json:"idle"`                    // latency before the load started
+	Loaded        *LatencyResult    `json:"loaded"`                  // latency while the load was running
+	Throughput    *ThroughputResult `json:"throughput"`              // throughput of the load
+	QueueingDelay time.Duration     `json:"queueingDelay"`           // increase of the median round-trip time under load in nanoseconds
+	HandshakeTime time.Duration     `json:"handshakeTime,omitempty"` // time the TLS handshake of the latency connection took in nanoseconds, it does not count towards the pings
+}
+
+// LoadedLatencyClient measures the latency of a network when it is idle and
+// when it is loaded by a throughput run. The difference shows how much
+// queueing delay the load causes, e.g. because of bufferbloat.
+type LoadedLatencyClient struct {
+	latency    LatencyClient
+	throughput ThroughputClient
+}
+
+// NewLoadedLatencyClient returns an instance of LoadedLatencyClient that
+// pings like latency and loads the network like throughput. Interval
+// reports of both are passed on while Run is in progress.
+func NewLoadedLatencyClient(latency LatencyClient, throughput ThroughputClient) LoadedLatencyClient {
+	return LoadedLatencyClient{
+		latency:    latency,
+		throughput: throughput,
+	}
+}
+
+// Run measures the latency over conn first while the network is idle,
+// then while the throughput client runs over loadConns, one stream per
+// connection, and returns both along with the throughput. The load runs
+// for the configured duration of the throughput client, or
+// DefaultLoadDuration if it has none, and the latency under load is
+// measured after the load had time to fill up the queues until it ends.
+//
+// All connections must go to the same Server, which runs the latency test
+// and its load as one test.
+func (c LoadedLatencyClient) Run(conn net.Conn, loadConns []net.Conn) (*LoadedLatencyResult, error) {
+	return c.RunContext(context.Background(), conn, loadConns)
+}
+
+// RunContext is like Run but aborts the test once ctx is done. The partial
+// result is then returned along with an error wrapping the context error.
+func (c LoadedLatencyClient) RunContext(ctx context.Context, conn net.Conn, loadConns []net.Conn) (*LoadedLatencyResult, error) {
+	if len(loadConns) == 0 {
+		return nil, errors.New("no connections for the load")
+	}
+	cookie, err := newCookie()
+	if err != nil {
+		return nil, err
+	}
+
+	lm, tc := c.latency, c.throughput
+	tc.cookie, tc.load = cookie, true
+	if tc.duration <= 0 {
+		tc.duration = int(DefaultLoadDuration / time.Millisecond)
+	}
+	desc := lm.descriptor()
+	desc.NumMsg *= 2
+	desc.Cookie, desc.Load = cookie, len(loadConns)
+	hs, err := handshakeTLS(ctx, conn)
+	if err == nil {
+		err = handshake(ctx, conn, desc)
+	}
+	if err != nil {
+		if ctxErr := interrupted(ctx, err); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	// a failing load aborts the pings
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d := watch(runCtx, conn)
+	defer d.stop()
+
+	result := &LoadedLatencyResult{HandshakeTime: hs}
+	start := time.Now()
+	idle := newLatencyMeter(lm.numMsg, lm.interval, lm.onInterval, start)
+	err = lm.ping(d, idle, start)
+	result.Idle = idle.result(time.Now())
+	if err != nil {
+		if ctxErr := interrupted(ctx, err); ctxErr != nil {
+			return result, ctxErr
+		}
+		return nil, err
+	}
+
+	loadErr := make(chan error, 1)
+	go func() {
+		var err error
+		result.Throughput, err = tc.RunStreamsContext(runCtx, loadConns)
+		if err != nil {
+			cancel()
+		}
+		loadErr <- err
+	}()
+
+	warmup := time.Duration(tc.duration) * time.Millisecond / 5
+	if warmup > maxLoadWarmup {
+		warmup = maxLoadWarmup
+	}
+	timer := time.NewTimer(warmup)
+	select {
+	case <-timer.C:
+	case <-runCtx.Done():
+		timer.Stop()
+	}
+
+	// stop pinging when the load ends
+	lm.timeout = tc.duration - int(warmup/time.Millisecond)
+	start = time.Now()
+	loaded := newLatencyMeter(lm.numMsg, lm.interval, lm.onInterval, start)
+	err = runCtx.Err()
+	if err == nil {
+		err = lm.ping(d, loaded, start)
+	}
+	if err == nil && isDatagram(conn) {
+		err = sendPingFins(d)
+	}
+	result.Loaded = loaded.result(time.Now())
+
+	if lerr := <-loadErr; lerr != nil {
+		err = fmt.Errorf("load: %w", lerr)
+	}
+	if err != nil {
+		if ctxErr := interrupted(ctx, err); ctxErr != nil {
+			return result, ctxErr
+		}
+		return nil, err
+	}
+
+	result.QueueingDelay = result.Loaded.P50 - result.Idle.P50
+	return result, nil
+}
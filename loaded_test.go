@@ -0,0 +1,174 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runLoadedLatency runs a latency test loaded by two throughput streams
+// against the server listening on addr.
+func runLoadedLatency(t *testing.T, addr string) *LoadedLatencyResult {
+	lo := DefaultLatencyOptions()
+	lo.NumMsg = 100
+	to := DefaultThroughputOptions()
+	to.MsgSize = 64000
+	to.Duration = 500
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Error making connection: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	result, err := NewLoadedLatencyClient(lo.LatencyClient(), to.ThroughputClient()).Run(conns[0], conns[1:])
+	if err != nil {
+		t.Fatalf("Error running latency test under load: %v", err)
+	}
+	if len(result.Idle.Samples) != lo.NumMsg || len(result.Loaded.Samples) == 0 {
+		t.Errorf("expected %d idle and some loaded round-trip times, got %d and %d", lo.NumMsg, len(result.Idle.Samples), len(result.Loaded.Samples))
+	}
+	if result.Throughput == nil || len(result.Throughput.Streams) != 2 || result.Throughput.Bytes == 0 {
+		t.Errorf("expected load of two streams, got %+v", result.Throughput)
+	}
+	if result.QueueingDelay != result.Loaded.P50-result.Idle.P50 {
+		t.Errorf("expected queueing delay %v, got %v", result.Loaded.P50-result.Idle.P50, result.QueueingDelay)
+	}
+	return result
+}
+
+func TestLoadedLatency(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	addr := fmt.Sprintf(":%d", randPort())
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	results := make(chan *ServerResult, 1)
+	go func() {
+		result, err := NewServer().Run(l)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result
+	}()
+
+	client := runLoadedLatency(t, addr)
+
+	result := <-results
+	if result == nil || result.Type != TestTypeLatency || result.Throughput == nil {
+		t.Fatalf("expected latency test with load, got %+v", result)
+	}
+	if result.Throughput.Bytes != client.Throughput.ReceivedBytes {
+		t.Errorf("expected server to receive the %d bytes of the load, got %d", client.Throughput.ReceivedBytes, result.Throughput.Bytes)
+	}
+	t.Logf("queueing delay: %v", client.QueueingDelay)
+}
+
+func TestLoadedLatencyServe(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+
+	// the load runs in the session of the latency test of a serial server
+	done := make(chan error, 1)
+	go func() {
+		done <- o.Server().Serve(l)
+	}()
+	runLoadedLatency(t, o.Addr)
+
+	l.Close()
+	if err := <-done; err != nil {
+		t.Errorf("expected server to shut down cleanly, got %v", err)
+	}
+}
+
+func TestLoadedLatencyStrayLoad(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		_ = o.Server().Serve(l)
+	}()
+
+	// a load without its latency test must not bypass the sessions
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	desc := TestDescriptor{Type: TestTypeThroughput, MsgSize: 1024, Duration: 100, Load: 1, Cookie: "stray"}
+	if err := handshake(context.Background(), conn, desc); err == nil || !strings.Contains(err.Error(), errNoLoadedLatency.Error()) {
+		t.Errorf("expected load without latency test to be rejected, got %v", err)
+	}
+
+	runLoadedLatency(t, o.Addr)
+}
+
+func TestLoadedLatencyUnsupported(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		_ = o.LatencyServer().Run(l)
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = NewLoadedLatencyClient(o.LatencyClient(), DefaultThroughputOptions().ThroughputClient()).Run(conn, []net.Conn{conn})
+	if err == nil {
+		t.Error("expected latency server to reject the test")
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// seqSize is the size of the sequence number that starts every ping of an
+// open-loop run over a stream connection.
+const seqSize = 8
+
+// pingSchedule is the schedule of the pings of an open-loop latency run.
+type pingSchedule struct {
+	start  time.Time
+	period time.Duration
+	count  uint64
+}
+
+// schedule returns the schedule of an open-loop run starting at start.
+// Pings are sent at the configured rate until the configured number of
+// pings is sent or the timeout is reached.
+func (lm LatencyClient) schedule(start time.Time) pingSchedule {
+	s := pingSchedule{
+		start:  start,
+		period: time.Duration(float64(time.Second) / float64(lm.rate)),
+		count:  uint64(lm.numMsg),
+	}
+	if s.period <= 0 {
+		s.period = 1
+	}
+	timeout := time.Duration(lm.timeout) * time.Millisecond
+	if n := uint64(timeout/s.period) + 1; n < s.count {
+		s.count = n
+	}
+	return s
+}
+
+// due returns the time the ping with sequence number seq is to be sent.
+func (s pingSchedule) due(seq uint64) time.Time {
+	return s.start.Add(time.Duration(seq) * s.period)
+}
+
+// pingOpenLoop sends the pings at the configured rate regardless of the
+// replies and matches the replies by sequence number. Round-trip times are
+// measured from the time a ping was due, so a ping delayed by a blocked
+// write or the reply to an earlier ping counts the delay in.
+func (lm LatencyClient) pingOpenLoop(d *deadlines, meter *latencyMeter, start time.Time) error {
+	datagram := isDatagram(d.conn)
+	if datagram {
+		if err := lm.checkPackets(); err != nil {
+			return err
+		}
+	} else if lm.msgSize < seqSize {
+		return fmt.Errorf("message size %d is too small for open-loop pings, it must be at least %d", lm.msgSize, seqSize)
+	}
+	s := lm.schedule(start)
+
+	// the side failing first aborts the other one and its error is the
+	// one returned
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() { firstErr = err })
+	}
+
+	abort := make(chan struct{})
+	sendDone := make(chan struct{})
+	go func() {
+		defer close(sendDone)
+		if err := lm.sendScheduled(d, s, datagram); err != nil {
+			fail(err)
+			close(abort)
+			d.setRead(aLongTimeAgo)
+		}
+	}()
+
+	var err error
+	if datagram {
+		err = lm.receivePongs(d, meter, s, abort)
+	} else {
+		err = receiveReplies(d, meter, s, lm.msgSize)
+	}
+	if err != nil {
+		fail(err)
+		d.setWrite(aLongTimeAgo)
+	}
+	<-sendDone
+	return firstErr
+}
+
+// sendScheduled sends the pings of the schedule at their due times. A ping
+// that is late, e.g. because the previous write blocked, is sent right
+// away.
+func (lm LatencyClient) sendScheduled(d *deadlines, s pingSchedule, datagram bool) error {
+	buf := make([]byte, lm.msgSize)
+	if datagram {
+		buf[0] = kindPing
+	}
+	for seq := uint64(0); seq < s.count; seq++ {
+		due := s.due(seq)
+		if delay := time.Until(due); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-d.ctx.Done():
+				timer.Stop()
+				return d.ctx.Err()
+			}
+		}
+
+		if datagram {
+			binary.BigEndian.PutUint64(buf[1:], seq)
+			binary.BigEndian.PutUint64(buf[9:], uint64(due.UnixNano()))
+		} else {
+			binary.BigEndian.PutUint64(buf, seq)
+		}
+		if _, err := d.conn.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveReplies reads the replies to the pings of the schedule from a
+// stream connection, which delivers them in order.
+func receiveReplies(d *deadlines, meter *latencyMeter, s pingSchedule, msgSize int) error {
+	buf := make([]byte, msgSize)
+	for seq := uint64(0); seq < s.count; seq++ {
+		if _, err := io.ReadFull(d.conn, buf); err != nil {
+			return err
+		}
+		now := time.Now()
+		if got := binary.BigEndian.Uint64(buf); got != seq {
+			return fmt.Errorf("unexpected reply to ping %d, expected %d", got, seq)
+		}
+		meter.add(now.Sub(s.due(seq)), now)
+	}
+	return nil
+}
+
+// receivePongs reads the replies to the ping datagrams of the schedule,
+// which may arrive out of order, more than once or not at all. A ping
+// without a reply within the ping timeout from its due time is counted as
+// lost, late replies are ignored. It stops early if abort is closed.
+func (lm LatencyClient) receivePongs(d *deadlines, meter *latencyMeter, s pingSchedule, abort <-chan struct{}) error {
+	defer d.setRead(time.Time{})
+
+	pingTimeout := lm.packetPingTimeout()
+	buf := make([]byte, maxDatagramSize)
+	// answered holds the pings after next that got a reply, next is the
+	// first ping that neither got a reply nor is lost yet
+	answered := make(map[uint64]bool)
+	var next uint64
+	for next < s.count {
+		d.setRead(s.due(next).Add(pingTimeout))
+		select {
+		case <-abort:
+			return errors.New("aborted")
+		default:
+		}
+
+		n, err := d.conn.Read(buf)
+		now := time.Now()
+		if err != nil && !(errors.Is(err, os.ErrDeadlineExceeded) && d.ctx.Err() == nil) {
+			return err
+		}
+		if err == nil && n >= packetHeaderSize && buf[0] == kindPing {
+			seq := binary.BigEndian.Uint64(buf[1:])
+			due := s.due(seq)
+			if seq >= next && seq < s.count && !answered[seq] && now.Before(due.Add(pingTimeout)) {
+				answered[seq] = true
+				meter.add(now.Sub(due), now)
+			}
+		}
+
+		for next < s.count {
+			if answered[next] {
+				delete(answered, next)
+			} else if now.Before(s.due(next).Add(pingTimeout)) {
+				break
+			} else {
+				meter.addLost(now)
+			}
+			next++
+		}
+	}
+	return nil
+}
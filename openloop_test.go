@@ -0,0 +1,178 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLatencyOpenLoop(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf(":%d", randPort())
+	o.NumMsg = 200
+	o.Rate = 1000
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	// a server that stalls for 100ms before answering the 50th ping
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		desc, err := readTest(context.Background(), conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := replyTest(conn, nil); err != nil {
+			t.Error(err)
+			return
+		}
+
+		buf := make([]byte, desc.MsgSize)
+		for i := 0; ; i++ {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			if i == 50 {
+				time.Sleep(100 * time.Millisecond)
+			}
+			if _, err := conn.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := o.LatencyClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+	if len(result.Samples) != o.NumMsg {
+		t.Fatalf("expected %d round-trip times, got %d", o.NumMsg, len(result.Samples))
+	}
+	if result.ElapsedTime < 199*time.Millisecond {
+		t.Errorf("expected pings to be sent at 1000/s, took %v", result.ElapsedTime)
+	}
+
+	// the pings due while the server stalled wait for it too
+	stalled := 0
+	for _, rtt := range result.Samples {
+		if rtt >= 50*time.Millisecond {
+			stalled++
+		}
+	}
+	if stalled < 30 {
+		t.Errorf("expected the stall to delay about 50 pings by 50ms or more, got %d", stalled)
+	}
+}
+
+func TestLatencyOpenLoopUDP(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Network = "udp"
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	o.NumMsg = 100
+	o.Rate = 500
+	o.PingTimeout = 50
+
+	pc, err := net.ListenPacket(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	l := NewPacketListener(pc)
+	defer l.Close()
+
+	// a server that drops every 10th ping, answers every 5th one twice and
+	// every 7th one after the next
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := readTest(context.Background(), conn); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := replyTest(conn, nil); err != nil {
+			t.Error(err)
+			return
+		}
+
+		buf := make([]byte, maxDatagramSize)
+		var held []byte
+		for i := 0; ; i++ {
+			n, err := conn.Read(buf)
+			if err != nil || buf[0] == kindFin {
+				return
+			}
+			switch {
+			case i%10 == 9:
+				continue
+			case i%7 == 6:
+				held = append([]byte(nil), buf[:n]...)
+				continue
+			}
+			_, _ = conn.Write(buf[:n])
+			if i%5 == 4 {
+				_, _ = conn.Write(buf[:n])
+			}
+			if held != nil {
+				_, _ = conn.Write(held)
+				held = nil
+			}
+		}
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := o.LatencyClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+	if result.Lost != 10 || len(result.Samples) != 90 {
+		t.Errorf("expected 10 lost and 90 answered pings, got %d lost and %d answered", result.Lost, len(result.Samples))
+	}
+	if result.Max >= 50*time.Millisecond {
+		t.Errorf("expected round-trip times below the ping timeout, got %v", result.Max)
+	}
+}
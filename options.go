@@ -18,44 +18,115 @@ package benchmate
 
 // Options contains configuration options for clients and servers.
 type Options struct {
-	MsgSize    int    `json:"msgSize"`    // size of messages in bytes
-	NumMsg     int    `json:"numMsg"`     // number of messages to send
-	Addr       string `json:"addr"`       // server listens on this address
-	Network    string `json:"network"`    // network type (unix or tcp)
-	ClientPort int    `json:"clientPort"` // local port used by client
-	Timeout    int    `json:"timeout"`    // in milliseconds
+	MsgSize     int            `json:"msgSize"`          // size of messages in bytes
+	NumMsg      int            `json:"numMsg"`           // number of messages to send
+	Addr        string         `json:"addr"`             // server listens on this address
+	Network     string         `json:"network"`          // network type (tcp, unix, tls, udp, unixgram or any registered with RegisterTransport)
+	ClientPort  int            `json:"clientPort"`       // local port used by client, an ephemeral one if 0
+	SourceAddr  string         `json:"sourceAddr"`       // local IP address used by client, any if empty
+	Interface   string         `json:"interface"`        // network interface the client is bound to, Linux only
+	Timeout     int            `json:"timeout"`          // in milliseconds
+	Duration    int            `json:"duration"`         // run throughput client for this long (ms) instead of sending NumMsg messages
+	Interval    int            `json:"interval"`         // report client progress every Interval ms, 0 disables interval reports
+	Direction   string         `json:"direction"`        // direction of throughput runs (forward, reverse or bidir)
+	Parallel    int            `json:"parallel"`         // number of parallel streams of throughput runs or dialers of connect runs
+	Concurrent  bool           `json:"concurrent"`       // serve clients concurrently when servers run in daemon mode
+	Bitrate     int64          `json:"bitrate"`          // target bitrate (bits/s) of throughput runs, 0 means unlimited, or DefaultDatagramBitrate over udp
+	PingTimeout int            `json:"pingTimeout"`      // timeout (ms) of a ping of latency runs over udp or unixgram, 0 means DefaultPingTimeout
+	Rate        int            `json:"rate"`             // pings per second of open-loop latency runs, 0 sends the next ping once the reply arrived
+	ReqSize     int            `json:"reqSize"`          // request size of transaction runs in bytes, 0 means MsgSize
+	RespSize    int            `json:"respSize"`         // response size of transaction runs in bytes, 0 means MsgSize
+	Connect     bool           `json:"connect"`          // open a new connection for every transaction of transaction runs
+	TLS         *TLSOptions    `json:"tls,omitempty"`    // run the tests over TLS, see WrapListener and WrapConn
+	Socket      *SocketOptions `json:"socket,omitempty"` // tune the sockets of clients and servers
+}
+
+// Server returns a Server instance configured with the options.
+func (o Options) Server() Server {
+	return Server{
+		latency:    o.LatencyServer(),
+		throughput: o.ThroughputServer(),
+		concurrent: o.Concurrent,
+	}
 }
 
 // LatencyServer returns a LatencyServer instance configured with the options.
 func (o Options) LatencyServer() LatencyServer {
 	return LatencyServer{
-		msgSize: o.MsgSize,
-		numMsg:  o.NumMsg,
+		msgSize:    o.MsgSize,
+		numMsg:     o.NumMsg,
+		concurrent: o.Concurrent,
 	}
 }
 
 // LatencyClient returns a LatencyClient instance configured with the options.
 func (o Options) LatencyClient() LatencyClient {
 	return LatencyClient{
-		msgSize: o.MsgSize,
-		numMsg:  o.NumMsg,
-		timeout: o.Timeout,
+		msgSize:     o.MsgSize,
+		numMsg:      o.NumMsg,
+		timeout:     o.Timeout,
+		pingTimeout: o.PingTimeout,
+		rate:        o.Rate,
+		interval:    o.Interval,
 	}
 }
 
 // ThroughputServer returns a ThroughputServer instance configured with the options.
 func (o Options) ThroughputServer() ThroughputServer {
 	return ThroughputServer{
-		msgSize: o.MsgSize,
+		throughputConfig: o.throughputConfig(),
+		concurrent:       o.Concurrent,
 	}
 }
 
 // ThroughputClient returns a ThroughputClient instance configured with the options.
 func (o Options) ThroughputClient() ThroughputClient {
 	return ThroughputClient{
-		msgSize: o.MsgSize,
-		numMsg:  o.NumMsg,
-		timeout: o.Timeout,
+		throughputConfig: o.throughputConfig(),
+	}
+}
+
+// TransactionClient returns a TransactionClient instance configured with the options.
+func (o Options) TransactionClient() TransactionClient {
+	c := TransactionClient{
+		reqSize:  o.ReqSize,
+		respSize: o.RespSize,
+		numMsg:   o.NumMsg,
+		timeout:  o.Timeout,
+		duration: o.Duration,
+		interval: o.Interval,
+	}
+	if c.reqSize <= 0 {
+		c.reqSize = o.MsgSize
+	}
+	if c.respSize <= 0 {
+		c.respSize = o.MsgSize
+	}
+	return c
+}
+
+// ConnectClient returns a ConnectClient instance configured with the options.
+func (o Options) ConnectClient() ConnectClient {
+	return ConnectClient{
+		numMsg:   o.NumMsg,
+		timeout:  o.Timeout,
+		duration: o.Duration,
+		parallel: o.Parallel,
+		interval: o.Interval,
+	}
+}
+
+// throughputConfig returns the throughput parameters of the options.
+func (o Options) throughputConfig() throughputConfig {
+	return throughputConfig{
+		msgSize:   o.MsgSize,
+		numMsg:    o.NumMsg,
+		timeout:   o.Timeout,
+		duration:  o.Duration,
+		interval:  o.Interval,
+		direction: o.Direction,
+		parallel:  o.Parallel,
+		bitrate:   o.Bitrate,
 	}
 }
 
@@ -63,7 +134,7 @@ func (o Options) ThroughputClient() ThroughputClient {
 //	{
 //		MsgSize:    128,
 //		NumMsg:     10000,
-//		Addr:       ":13501",
+//		Addr:       ":13500",
 //		Network:    "tcp",
 //		ClientPort: 0,
 //		Timeout:    120000,
@@ -72,7 +143,7 @@ func DefaultLatencyOptions() Options {
 	return Options{
 		MsgSize:    128,
 		NumMsg:     10000,
-		Addr:       ":13501",
+		Addr:       ":13500",
 		Network:    "tcp",
 		ClientPort: 0,
 		Timeout:    120000,
@@ -87,6 +158,7 @@ func DefaultLatencyOptions() Options {
 //		Network:    "tcp",
 //		ClientPort: 0,
 //		Timeout:    120000,
+//		Direction:  "forward",
 //	}
 func DefaultThroughputOptions() Options {
 	return Options{
@@ -96,5 +168,46 @@ func DefaultThroughputOptions() Options {
 		Network:    "tcp",
 		ClientPort: 0,
 		Timeout:    120000,
+		Direction:  DirectionForward,
+	}
+}
+
+// DefaultTransactionOptions are
+//	{
+//		MsgSize:    1,
+//		NumMsg:     10000,
+//		Addr:       ":13500",
+//		Network:    "tcp",
+//		ClientPort: 0,
+//		Timeout:    120000,
+//	}
+func DefaultTransactionOptions() Options {
+	return Options{
+		MsgSize:    1,
+		NumMsg:     10000,
+		Addr:       ":13500",
+		Network:    "tcp",
+		ClientPort: 0,
+		Timeout:    120000,
+	}
+}
+
+// DefaultConnectOptions are
+//	{
+//		NumMsg:     1000,
+//		Addr:       ":13500",
+//		Network:    "tcp",
+//		ClientPort: 0,
+//		Timeout:    120000,
+//		Parallel:   1,
+//	}
+func DefaultConnectOptions() Options {
+	return Options{
+		NumMsg:     1000,
+		Addr:       ":13500",
+		Network:    "tcp",
+		ClientPort: 0,
+		Timeout:    120000,
+		Parallel:   1,
 	}
 }
@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pacerBurst is how long a pacer may save up unused bandwidth, e.g. while
+// the previous write blocked, and send at a higher rate to make up for it.
+const pacerBurst = 10 * time.Millisecond
+
+// pacer spaces out writes with a token bucket so that they do not exceed a
+// bitrate. The bucket starts empty and fills at the bitrate up to
+// pacerBurst worth of bytes. A write may take more tokens than there are,
+// the next one then waits until the debt is paid off.
+type pacer struct {
+	bitrate int64   // in bits per second, 0 disables pacing
+	tokens  float64 // in bytes, negative if a write took more than there were
+	burst   float64 // maximum number of tokens
+	last    time.Time
+	until   time.Time // wait returns at until at the latest if set, e.g. the end of a duration run
+}
+
+// newPacer returns a pacer for writes starting at start.
+func newPacer(bitrate int64, start time.Time) *pacer {
+	return &pacer{
+		bitrate: bitrate,
+		burst:   float64(bitrate) / 8 * pacerBurst.Seconds(),
+		last:    start,
+	}
+}
+
+// wait blocks until n more bytes can be written without exceeding the
+// bitrate. It returns the context error if ctx is done before.
+func (p *pacer) wait(ctx context.Context, n int) error {
+	if p.bitrate <= 0 {
+		return nil
+	}
+
+	rate := float64(p.bitrate) / 8
+	now := time.Now()
+	p.tokens += now.Sub(p.last).Seconds() * rate
+	if p.tokens > p.burst {
+		p.tokens = p.burst
+	}
+	p.last = now
+
+	if p.tokens < 0 {
+		delay := time.Duration(-p.tokens / rate * float64(time.Second))
+		if !p.until.IsZero() && now.Add(delay).After(p.until) {
+			delay = p.until.Sub(now)
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p.tokens -= float64(n)
+	return nil
+}
+
+// ParseBitrate parses a bitrate in bits per second with an optional k, M,
+// G or T suffix for powers of 1000, which may be followed by "bit" or
+// "bps", e.g. "100M" or "200Mbit".
+func ParseBitrate(s string) (int64, error) {
+	num, mult := s, 1.0
+	for _, unit := range []string{"bit", "bps"} {
+		if strings.HasSuffix(num, unit) {
+			num = strings.TrimSuffix(num, unit)
+			break
+		}
+	}
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'k', 'K':
+			mult = 1e3
+		case 'm', 'M':
+			mult = 1e6
+		case 'g', 'G':
+			mult = 1e9
+		case 't', 'T':
+			mult = 1e12
+		}
+		if mult > 1 {
+			num = num[:n-1]
+		}
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	// 2^63 is the smallest float64 beyond the range of int64
+	if err != nil || math.IsNaN(f) || f < 0 || math.IsInf(f*mult, 0) || f*mult >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid bitrate %q", s)
+	}
+	return int64(f * mult), nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPacer(t *testing.T) {
+	start := time.Now()
+	p := newPacer(8*1000*1000, start)
+	// 100 kB at 1 MB/s take 100ms, the last write may start after 90ms
+	for i := 0; i < 10; i++ {
+		if err := p.wait(context.Background(), 10*1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected writes to take about 90ms, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newPacer(8, time.Now()).wait(ctx, 1); err != nil {
+		t.Errorf("expected first write to pass, got %v", err)
+	}
+	// the second write would be due in 1000s
+	p = newPacer(8, time.Now())
+	p.until = time.Now().Add(50 * time.Millisecond)
+	_ = p.wait(context.Background(), 1000)
+	if err := p.wait(context.Background(), 1); err != nil || time.Now().Before(p.until) || time.Since(p.until) > time.Second {
+		t.Errorf("expected wait to end at %v, ended at %v: %v", p.until, time.Now(), err)
+	}
+
+	p = newPacer(8, time.Now())
+	_ = p.wait(ctx, 1000)
+	if err := p.wait(ctx, 1); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"0":       0,
+		"1000":    1000,
+		"10k":     10 * 1000,
+		"100M":    100 * 1000 * 1000,
+		"1.5G":    1500 * 1000 * 1000,
+		"2T":      2 * 1000 * 1000 * 1000 * 1000,
+		"200Mbit": 200 * 1000 * 1000,
+		"64kbps":  64 * 1000,
+		"500bit":  500,
+	} {
+		bitrate, err := ParseBitrate(s)
+		if err != nil || bitrate != expected {
+			t.Errorf("%s: expected %d, got %d, %v", s, expected, bitrate, err)
+		}
+	}
+
+	for _, s := range []string{"", "M", "-1", "10X", "bit", "10Mbitbit", "NaN", "Inf", "+InfM", "1e400M", "1e300M", "9.3e18"} {
+		if _, err := ParseBitrate(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// packetBacklog is the number of datagrams queued for a peer before
+// further ones are dropped, like a full socket buffer would.
+const packetBacklog = 1024
+
+// acceptBacklog is the number of peers waiting to be accepted before
+// further ones are ignored.
+const acceptBacklog = 16
+
+// packetListener splits the datagrams received on a PacketConn into one
+// connection per peer, so tests over datagram sockets can be served like
+// tests over stream sockets.
+type packetListener struct {
+	pc       net.PacketConn
+	accepted chan *packetConn
+	closed   chan struct{}
+	once     sync.Once
+
+	mu    sync.Mutex
+	conns map[string]*packetConn
+}
+
+// NewPacketListener returns a listener that accepts a connection for every
+// peer that starts sending datagrams to pc with a test descriptor, e.g.
+//
+//	pc, _ := net.ListenPacket("udp", ":13500")
+//	NewServer().Serve(NewPacketListener(pc))
+//
+// Reading from a connection returns the datagrams of its peer, writing to
+// it sends a datagram to the peer. Closing the listener closes pc.
+func NewPacketListener(pc net.PacketConn) net.Listener {
+	l := &packetListener{
+		pc:       pc,
+		accepted: make(chan *packetConn, acceptBacklog),
+		closed:   make(chan struct{}),
+		conns:    make(map[string]*packetConn),
+	}
+	go l.receive()
+	return l
+}
+
+// receive hands the datagrams received on the PacketConn over to the
+// connections of their senders until the PacketConn is closed.
+func (l *packetListener) receive() {
+	defer l.Close()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+
+		l.mu.Lock()
+		conn, ok := l.conns[addr.String()]
+		if !ok && bytes.HasPrefix(buf[:n], []byte(controlMagic)) {
+			conn = newPacketConn(l, addr)
+			l.conns[addr.String()] = conn
+		}
+		l.mu.Unlock()
+		if conn == nil {
+			// a straggler of a finished test or not a benchmate client
+			continue
+		}
+
+		if !ok {
+			select {
+			case l.accepted <- conn:
+			default:
+				l.remove(conn)
+				continue
+			}
+		}
+
+		select {
+		case conn.in <- append([]byte(nil), buf[:n]...):
+		default:
+		}
+	}
+}
+
+// Accept waits for the next peer to send a test descriptor.
+func (l *packetListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accepted:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener and the PacketConn. Accepted connections fail
+// to read from then.
+func (l *packetListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closed)
+		err = l.pc.Close()
+	})
+	return err
+}
+
+// Addr returns the local address of the PacketConn.
+func (l *packetListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// remove forgets the connection so that the next datagrams of its peer
+// are dropped until the peer starts a new test.
+func (l *packetListener) remove(c *packetConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[c.remote.String()] == c {
+		delete(l.conns, c.remote.String())
+	}
+}
+
+// packetConn is a connection to a peer accepted by a packetListener. Reads
+// of less than a datagram return the rest of it on the next read, so the
+// control messages can be read like from a stream.
+type packetConn struct {
+	l      *packetListener
+	remote net.Addr
+	in     chan []byte
+	rest   []byte
+	closed chan struct{}
+	once   sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+	changed  chan struct{} // closed and replaced when the deadline changes
+}
+
+func newPacketConn(l *packetListener, remote net.Addr) *packetConn {
+	return &packetConn{
+		l:       l,
+		remote:  remote,
+		in:      make(chan []byte, packetBacklog),
+		closed:  make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+}
+
+// Read reads the next datagram of the peer.
+func (c *packetConn) Read(b []byte) (int, error) {
+	if len(c.rest) > 0 {
+		n := copy(b, c.rest)
+		c.rest = c.rest[n:]
+		return n, nil
+	}
+
+	for {
+		c.mu.Lock()
+		deadline, changed := c.deadline, c.changed
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		p, err := c.wait(timeout, changed)
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, err
+		}
+		if p != nil {
+			n := copy(b, p)
+			c.rest = p[n:]
+			return n, nil
+		}
+	}
+}
+
+// wait waits for the next datagram of the peer. It returns neither a
+// datagram nor an error if the deadline changed.
+func (c *packetConn) wait(timeout <-chan time.Time, changed <-chan struct{}) ([]byte, error) {
+	select {
+	case p := <-c.in:
+		return p, nil
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	case <-changed:
+		return nil, nil
+	case <-c.closed:
+		return nil, net.ErrClosed
+	case <-c.l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Write sends b to the peer as one datagram.
+func (c *packetConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.l.pc.WriteTo(b, c.remote)
+}
+
+// Close closes the connection. Later datagrams of the peer are dropped
+// unless they start a new test.
+func (c *packetConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.l.remove(c)
+	})
+	return nil
+}
+
+// LocalAddr returns the local address of the listener.
+func (c *packetConn) LocalAddr() net.Addr {
+	return c.l.pc.LocalAddr()
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *packetConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline sets the read deadline. Writes of datagrams do not block.
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline, also for reads in progress.
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline does nothing, writes of datagrams do not block.
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// isDatagram reports whether conn is a connection of a datagram socket,
+// e.g. UDP.
+func isDatagram(conn net.Conn) bool {
+	addr := conn.LocalAddr()
+	if addr == nil {
+		return false
+	}
+	switch addr.Network() {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPacketListener(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	pc, err := net.ListenPacket("udp", fmt.Sprintf("127.0.0.1:%d", randPort()))
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	l := NewPacketListener(pc)
+	defer l.Close()
+
+	var peers []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("udp", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatalf("Error making connection: %v", err)
+		}
+		defer conn.Close()
+		peers = append(peers, conn)
+	}
+
+	// datagrams not starting a test are dropped
+	if _, err := peers[0].Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for i, peer := range peers {
+		if _, err := peer.Write([]byte(fmt.Sprintf("%s%d", controlMagic, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := range peers {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatalf("Error accepting connection: %v", err)
+		}
+		defer conn.Close()
+
+		// a short read returns the rest of the datagram on the next read
+		buf := make([]byte, len(controlMagic))
+		if _, err := conn.Read(buf); err != nil || string(buf) != controlMagic {
+			t.Errorf("expected %q, got %q, %v", controlMagic, buf, err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != fmt.Sprint(i) {
+			t.Errorf("expected %d, got %q, %v", i, buf[:n], err)
+		}
+
+		if _, err := conn.Write([]byte("pong")); err != nil {
+			t.Fatal(err)
+		}
+		n, err = peers[i].Read(buf)
+		if err != nil || string(buf[:n]) != "pong" {
+			t.Errorf("expected pong, got %q, %v", buf[:n], err)
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		if _, err := conn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("expected deadline to be exceeded, got %v", err)
+		}
+	}
+
+	l.Close()
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected closed listener, got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// serve accepts connections on l until l is closed or ctx is done and calls
+// handle for every connection in its own goroutine, so a client that is
+// slow to send its test descriptor cannot hold up others. handle is
+// responsible for closing the connection. serve waits for all handlers
+// to return before it returns. It returns nil if l was closed and an error
+// wrapping the context error if ctx is done. Temporary errors of Accept,
+// like timeouts or running out of file descriptors, are logged and
+// retried after a delay, serve returns other errors.
+func serve(ctx context.Context, l net.Listener, handle func(net.Conn)) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	stop := watchListener(ctx, l)
+	defer stop()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctxErr := interrupted(ctx, err); ctxErr != nil {
+				return ctxErr
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			if !temporary(err) {
+				return err
+			}
+			delay = acceptDelay(delay)
+			log.Printf("accept error: %v, retrying in %v", err, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		delay = 0
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handle(conn)
+		}()
+	}
+}
+
+// temporary reports whether the error err of Accept may go away by
+// itself, like timeouts or running out of file descriptors, so accepting
+// is worth retrying as net/http does.
+func temporary(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && (ne.Timeout() || ne.Temporary())
+}
+
+// acceptDelay returns the delay before retrying Accept after it failed
+// following the delay last, doubling from 5ms up to 1s like net/http.
+func acceptDelay(last time.Duration) time.Duration {
+	if last == 0 {
+		return 5 * time.Millisecond
+	}
+	if last *= 2; last > time.Second {
+		return time.Second
+	}
+	return last
+}
+
+// serveTests is like serve but reads the test descriptor sent by the client
+// before it calls handle. Connections that do not start with a valid test
+// descriptor are rejected. The connection is closed once handle returns.
+func serveTests(ctx context.Context, l net.Listener, handle func(net.Conn, TestDescriptor)) error {
+	return serve(ctx, l, func(conn net.Conn) {
+		defer conn.Close()
+
+		desc, err := readTest(ctx, conn)
+		if err != nil {
+			if ctxErr := interrupted(ctx, err); ctxErr != nil {
+				err = ctxErr
+			}
+			log.Printf("rejected connection from %v: %v", conn.RemoteAddr(), rejectTest(conn, err))
+			return
+		}
+		handle(conn, desc)
+	})
+}
+
+// sessions runs the sessions of a server one after another unless the
+// server serves clients concurrently. It also tracks the latency tests
+// under load of the server, the streams of their load run in their
+// session.
+type sessions struct {
+	concurrent bool
+	mu         sync.Mutex
+
+	loadMu sync.Mutex
+	loads  map[string]int // running latency tests under load by cookie
+}
+
+// begin waits for the running session to end unless sessions run
+// concurrently or s is nil, as for the load of a latency test.
+func (s *sessions) begin() {
+	if s != nil && !s.concurrent {
+		s.mu.Lock()
+	}
+}
+
+// end ends a session started with begin.
+func (s *sessions) end() {
+	if s != nil && !s.concurrent {
+		s.mu.Unlock()
+	}
+}
+
+// beginLoad marks the latency test under load with cookie as running, so
+// the streams of its load are admitted until endLoad is called.
+func (s *sessions) beginLoad(cookie string) {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	if s.loads == nil {
+		s.loads = make(map[string]int)
+	}
+	s.loads[cookie]++
+}
+
+// endLoad ends a latency test under load started with beginLoad.
+func (s *sessions) endLoad(cookie string) {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	if s.loads[cookie]--; s.loads[cookie] <= 0 {
+		delete(s.loads, cookie)
+	}
+}
+
+// loading reports whether a latency test under load with cookie is
+// running.
+func (s *sessions) loading(cookie string) bool {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	return s.loads[cookie] > 0
+}
+
+// streamGroup holds the connections of a test with parallel streams.
+type streamGroup struct {
+	cookie  string
+	streams int
+	conns   []net.Conn
+	ready   chan struct{} // closed once all streams have joined
+	done    chan struct{} // closed once the test is done
+}
+
+// streamGroups collects the connections of tests with parallel streams
+// until all streams of a test have joined.
+type streamGroups struct {
+	mu     sync.Mutex
+	groups map[string]*streamGroup
+}
+
+// join adds conn to the group of the test described by desc. last is set
+// for the connection completing the group, its handler runs the test.
+func (g *streamGroups) join(desc TestDescriptor, conn net.Conn) (group *streamGroup, last bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.groups == nil {
+		g.groups = make(map[string]*streamGroup)
+	}
+	group, ok := g.groups[desc.Cookie]
+	if !ok {
+		group = &streamGroup{
+			cookie:  desc.Cookie,
+			streams: desc.Streams,
+			ready:   make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		g.groups[desc.Cookie] = group
+	}
+
+	group.conns = append(group.conns, conn)
+	if len(group.conns) < group.streams {
+		return group, false
+	}
+	delete(g.groups, desc.Cookie)
+	close(group.ready)
+	return group, true
+}
+
+// wait waits for the test of the group to be done. It gives up if not all
+// streams join in time or ctx is done before.
+func (g *streamGroups) wait(ctx context.Context, group *streamGroup) {
+	timer := time.NewTimer(handshakeTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-group.ready:
+	case <-timer.C:
+		g.abandon(group)
+	case <-ctx.Done():
+		g.abandon(group)
+	}
+	<-group.done
+}
+
+// abandon removes the group and releases everyone waiting for it unless
+// the group is complete.
+func (g *streamGroups) abandon(group *streamGroup) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.groups[group.cookie] == group {
+		delete(g.groups, group.cookie)
+		close(group.done)
+	}
+}
+
+// next returns a function that returns the connections of the group one
+// after another.
+func (group *streamGroup) next() func() (net.Conn, error) {
+	i := 0
+	return func() (net.Conn, error) {
+		conn := group.conns[i]
+		i++
+		return conn, nil
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+)
+
+// Server serves latency, throughput, transaction and connect tests on a
+// single listener. It reads the test descriptor every client starts with
+// and runs the test the client asks for, so only one port needs to be
+// reachable for all kinds of tests.
+type Server struct {
+	latency     LatencyServer
+	throughput  ThroughputServer
+	transaction transactionServer
+	concurrent  bool
+}
+
+// ServerResult contains the outcome of a test run by a Server.
+type ServerResult struct {
+	Type       string            `json:"type"`                 // type of the test, see TestTypeLatency, TestTypeThroughput, TestTypeTransaction and TestTypeConnect
+	Throughput *ThroughputResult `json:"throughput,omitempty"` // result of a throughput test
+}
+
+// NewServer creates a new instance of Server.
+func NewServer() Server {
+	return Server{}
+}
+
+// Run waits to get connection from a client and runs the test the client
+// asks for, see LatencyServer.Run, ThroughputServer.Run,
+// TransactionClient.Run and ConnectClient.Run.
+//
+// It accepts a listener. The following code will run the server at port 8888.
+//
+//	l, _ := net.Listen("tcp", ":8888")
+//	s.Run(l)
+func (s Server) Run(l net.Listener) (*ServerResult, error) {
+	return s.RunContext(context.Background(), l)
+}
+
+// RunContext is like Run but stops waiting for the client and aborts
+// blocked I/O once ctx is done. It then returns an error wrapping the
+// context error, along with the partial result of a throughput test.
+func (s Server) RunContext(ctx context.Context, l net.Listener) (*ServerResult, error) {
+	stop := watchListener(ctx, l)
+	defer stop()
+
+	conn, desc, err := acceptTest(ctx, l)
+	if err != nil {
+		return nil, latencyServerOutcome(ctx, err)
+	}
+	defer conn.Close()
+
+	switch desc.Type {
+	case TestTypeLatency:
+		if desc.Load > 0 {
+			return s.runLoadedLatency(ctx, l, conn, desc)
+		}
+		if err := s.latency.runTest(ctx, conn, desc); err != nil {
+			return nil, latencyServerOutcome(ctx, err)
+		}
+		return &ServerResult{Type: desc.Type}, nil
+	case TestTypeThroughput:
+		result, err := s.throughput.runTest(ctx, l, conn, desc)
+		result, err = throughputOutcome(ctx, result, err)
+		if result == nil {
+			return nil, err
+		}
+		return &ServerResult{Type: desc.Type, Throughput: result}, err
+	case TestTypeTransaction:
+		if err := s.transaction.runTest(ctx, conn, desc); err != nil {
+			return nil, latencyServerOutcome(ctx, err)
+		}
+		return &ServerResult{Type: desc.Type}, nil
+	case TestTypeConnect:
+		if err := runConnectTest(conn, desc); err != nil {
+			return nil, err
+		}
+		return &ServerResult{Type: desc.Type}, nil
+	default:
+		return nil, rejectTest(conn, fmt.Errorf("unknown test type %q", desc.Type))
+	}
+}
+
+// runLoadedLatency runs the latency test described by desc over conn while
+// the client loads the network with a throughput test. The connections of
+// the load are accepted on l, connections of other clients are rejected.
+func (s Server) runLoadedLatency(ctx context.Context, l net.Listener, conn net.Conn, desc TestDescriptor) (*ServerResult, error) {
+	// stop waiting for the load if the latency test fails before
+	loadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := watchListener(loadCtx, l)
+	defer stop()
+
+	latencyErr := make(chan error, 1)
+	go func() {
+		err := s.latency.runTest(ctx, conn, desc)
+		if err != nil {
+			cancel()
+		}
+		latencyErr <- err
+	}()
+
+	load, err := s.runLoad(loadCtx, l, desc)
+	load, err = throughputOutcome(ctx, load, err)
+	if lerr := <-latencyErr; lerr != nil {
+		return nil, latencyServerOutcome(ctx, lerr)
+	}
+	if err != nil {
+		err = fmt.Errorf("load: %w", err)
+		if load == nil {
+			return nil, err
+		}
+	}
+	return &ServerResult{Type: desc.Type, Throughput: load}, err
+}
+
+// runLoad runs the throughput test loading the network during the latency
+// test described by desc.
+func (s Server) runLoad(ctx context.Context, l net.Listener, desc TestDescriptor) (*ThroughputResult, error) {
+	for {
+		conn, loadDesc, err := acceptTest(ctx, l)
+		if err != nil {
+			if interrupted(ctx, err) != nil || errors.Is(err, net.ErrClosed) {
+				return nil, err
+			}
+			log.Printf("rejected connection: %v", err)
+			continue
+		}
+		if loadDesc.Type != TestTypeThroughput || loadDesc.Cookie != desc.Cookie {
+			err = rejectTest(conn, errors.New("server busy"))
+			log.Printf("rejected %s test from %v: %v", loadDesc.Type, conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		defer conn.Close()
+
+		return s.throughput.runTest(ctx, l, conn, loadDesc)
+	}
+}
+
+// Serve runs the server in daemon mode. It keeps accepting clients on l
+// and runs the test every client asks for. Tests run one after another,
+// whatever their type, unless the server is configured to serve clients
+// concurrently. Only the connections of connect tests are accepted right
+// away since nothing is measured over them. The outcome of every test is
+// logged. Serve returns nil after l is closed and all running tests are
+// done.
+func (s Server) Serve(l net.Listener) error {
+	return s.ServeContext(context.Background(), l)
+}
+
+// ServeContext is like Serve but also stops once ctx is done. Running
+// tests are interrupted then and an error wrapping the context error
+// is returned.
+func (s Server) ServeContext(ctx context.Context, l net.Listener) error {
+	sessions := &sessions{concurrent: s.concurrent}
+	groups := &streamGroups{}
+	return serveTests(ctx, l, func(conn net.Conn, desc TestDescriptor) {
+		switch desc.Type {
+		case TestTypeLatency:
+			s.latency.handle(ctx, conn, desc, sessions)
+		case TestTypeThroughput:
+			s.throughput.handle(ctx, conn, desc, sessions, groups)
+		case TestTypeTransaction:
+			s.transaction.handle(ctx, conn, desc, sessions)
+		case TestTypeConnect:
+			handleConnect(conn, desc)
+		default:
+			err := rejectTest(conn, fmt.Errorf("unknown test type %q", desc.Type))
+			log.Printf("rejected connection from %v: %v", conn.RemoteAddr(), err)
+		}
+	})
+}
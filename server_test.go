@@ -0,0 +1,219 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	lo := DefaultLatencyOptions()
+	lo.NumMsg = 100
+	lo.Addr = fmt.Sprintf(":%d", randPort())
+	to := DefaultThroughputOptions()
+	to.MsgSize = 64000
+	to.NumMsg = 100
+	to.Addr = lo.Addr
+
+	l, err := net.Listen(lo.Network, lo.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	results := make(chan *ServerResult, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			result, err := NewServer().Run(l)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- result
+		}
+	}()
+
+	conn, err := net.Dial(lo.Network, lo.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := lo.LatencyClient().Run(conn); err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+	if result := <-results; result == nil || result.Type != TestTypeLatency {
+		t.Errorf("expected latency test, got %+v", result)
+	}
+
+	conn, err = net.Dial(to.Network, to.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := to.ThroughputClient().Run(conn); err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+	result := <-results
+	if result == nil || result.Type != TestTypeThroughput || result.Throughput == nil {
+		t.Fatalf("expected throughput test, got %+v", result)
+	}
+	if expected := int64(to.NumMsg * to.MsgSize); result.Throughput.Bytes != expected {
+		t.Errorf("expected server to receive %d bytes, got %d", expected, result.Throughput.Bytes)
+	}
+}
+
+func TestServerServe(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultThroughputOptions()
+	o.MsgSize = 64000
+	o.NumMsg = 100
+	o.Parallel = 2
+	o.Concurrent = true
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- o.Server().Serve(l)
+	}()
+
+	// latency and throughput clients at the same time
+	errs := make(chan error, 2)
+	go func() {
+		lo := DefaultLatencyOptions()
+		lo.NumMsg = 100
+		conn, err := net.Dial(o.Network, o.Addr)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		_, err = lo.LatencyClient().Run(conn)
+		errs <- err
+	}()
+	go func() {
+		var conns []net.Conn
+		for i := 0; i < o.Parallel; i++ {
+			conn, err := net.Dial(o.Network, o.Addr)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			conns = append(conns, conn)
+		}
+		_, err := o.ThroughputClient().RunStreams(conns)
+		errs <- err
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Error running test: %v", err)
+		}
+	}
+
+	l.Close()
+	if err := <-done; err != nil {
+		t.Errorf("expected server to shut down cleanly, got %v", err)
+	}
+}
+
+// failingListener fails the first accepts like a process out of file
+// descriptors.
+type failingListener struct {
+	net.Listener
+	mu    sync.Mutex
+	fails int
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fails > 0 {
+		l.fails--
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	}
+	return l.Listener.Accept()
+}
+
+// brokenListener fails every accept with err, like a broken tunnel.
+type brokenListener struct {
+	net.Listener
+	err error
+}
+
+func (l brokenListener) Accept() (net.Conn, error) {
+	return nil, l.err
+}
+
+func TestServerServeAcceptError(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.NumMsg = 100
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- o.Server().Serve(&failingListener{Listener: l, fails: 3})
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := o.LatencyClient().Run(conn); err != nil {
+		t.Errorf("expected server to keep accepting, got %v", err)
+	}
+
+	l.Close()
+	if err := <-done; err != nil {
+		t.Errorf("expected server to shut down cleanly, got %v", err)
+	}
+
+	// permanent errors are not retried
+	broken := errors.New("tunnel broken")
+	go func() {
+		done <- o.Server().Serve(brokenListener{Listener: l, err: broken})
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, broken) {
+			t.Errorf("expected %v, got %v", broken, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("expected server to stop on a permanent error")
+	}
+}
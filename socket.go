@@ -0,0 +1,191 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// errNoSocket is returned for the socket of connections without one.
+var errNoSocket = errors.New("connection has no socket")
+
+// SocketOptions tune the sockets of tests, e.g. to find out whether
+// another congestion control algorithm helps a link. Zero values keep the
+// defaults of the kernel. Clients apply the options to the sockets they
+// dial, servers to the sockets they listen on and accept.
+type SocketOptions struct {
+	SendBuffer    int    `json:"sendBuffer,omitempty"`    // SO_SNDBUF in bytes
+	ReceiveBuffer int    `json:"receiveBuffer,omitempty"` // SO_RCVBUF in bytes
+	NoDelay       *bool  `json:"noDelay,omitempty"`       // TCP_NODELAY, which Go sets by default
+	Cork          bool   `json:"cork,omitempty"`          // TCP_CORK, Linux only
+	Congestion    string `json:"congestion,omitempty"`    // TCP_CONGESTION, e.g. cubic, bbr or reno, Linux only
+	MaxSegment    int    `json:"maxSegment,omitempty"`    // TCP_MAXSEG in bytes, not on Windows
+	NotSentLowat  int    `json:"notSentLowat,omitempty"`  // TCP_NOTSENT_LOWAT in bytes, Linux only
+}
+
+// SocketSettings are the options in effect on a socket as reported by the
+// kernel. Linux reports twice the buffer sizes set since it reserves space
+// for bookkeeping, and caps them at net.core.wmem_max and rmem_max.
+type SocketSettings struct {
+	SendBuffer    int    `json:"sendBuffer"`             // SO_SNDBUF in bytes
+	ReceiveBuffer int    `json:"receiveBuffer"`          // SO_RCVBUF in bytes
+	NoDelay       bool   `json:"noDelay,omitempty"`      // TCP_NODELAY
+	Cork          bool   `json:"cork,omitempty"`         // TCP_CORK
+	Congestion    string `json:"congestion,omitempty"`   // TCP_CONGESTION
+	MaxSegment    int    `json:"maxSegment,omitempty"`   // TCP_MAXSEG in bytes
+	NotSentLowat  int    `json:"notSentLowat,omitempty"` // TCP_NOTSENT_LOWAT in bytes
+}
+
+// control returns a function setting the options on sockets before they
+// connect or listen, as the Control of net.Dialer and net.ListenConfig.
+// Buffer sizes and the segment size are only fully effective if set
+// before the TCP handshake. It returns nil without options.
+func (s *SocketOptions) control() func(network, address string, c syscall.RawConn) error {
+	if s == nil {
+		return nil
+	}
+	return func(network, _ string, c syscall.RawConn) error {
+		return s.apply(network, c)
+	}
+}
+
+// apply sets the options on the socket c of network.
+func (s *SocketOptions) apply(network string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = setSocketOptions(int(fd), network, *s)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// tune sets the options on conn once it is connected, which is needed for
+// TCP_NODELAY since Go sets it on every new TCP connection.
+func (s *SocketOptions) tune(conn net.Conn) error {
+	if s == nil || s.NoDelay == nil {
+		return nil
+	}
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	return tc.SetNoDelay(*s.NoDelay)
+}
+
+// tunedListener sets the socket options on the connections it accepts.
+type tunedListener struct {
+	net.Listener
+	socket *SocketOptions
+}
+
+// Accept accepts the next connection and tunes its socket. The connection
+// is closed if that fails.
+func (l tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.socket.tune(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// SetDeadline sets the deadline of the wrapped listener if it supports
+// deadlines and fails otherwise, so watchListener closes the listener
+// instead.
+func (l tunedListener) SetDeadline(t time.Time) error {
+	dl, ok := l.Listener.(interface{ SetDeadline(time.Time) error })
+	if !ok {
+		return errors.New("listener does not support deadlines")
+	}
+	return dl.SetDeadline(t)
+}
+
+// socketSettings returns the options in effect on the socket of conn, or
+// nil if conn has no socket, like tunnels.
+func socketSettings(conn net.Conn) *SocketSettings {
+	c, err := rawConn(conn)
+	if err != nil {
+		return nil
+	}
+	network := ""
+	if addr := conn.LocalAddr(); addr != nil {
+		network = addr.Network()
+	}
+
+	var settings *SocketSettings
+	if err := c.Control(func(fd uintptr) {
+		settings = getSocketSettings(int(fd), network)
+	}); err != nil {
+		return nil
+	}
+	return settings
+}
+
+// rawConn returns the raw socket of conn.
+func rawConn(conn net.Conn) (syscall.RawConn, error) {
+	sc, ok := conn.(interface {
+		SyscallConn() (syscall.RawConn, error)
+	})
+	if !ok {
+		return nil, errNoSocket
+	}
+	return sc.SyscallConn()
+}
+
+// tlsConn is a TLS connection that gives access to the socket below it.
+type tlsConn struct {
+	*tls.Conn
+	raw net.Conn
+}
+
+// SyscallConn returns the raw socket of the connection below TLS if it
+// has one.
+func (c tlsConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.raw.(interface {
+		SyscallConn() (syscall.RawConn, error)
+	})
+	if !ok {
+		return nil, errNoSocket
+	}
+	return sc.SyscallConn()
+}
+
+// isTCP reports whether network is a TCP network.
+func isTCP(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return true
+	default:
+		return false
+	}
+}
+
+// boolInt returns 1 for true and 0 for false.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// setSocketOptions sets the options s on the socket fd of network.
+func setSocketOptions(fd int, network string, s SocketOptions) error {
+	if s.SendBuffer > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, s.SendBuffer); err != nil {
+			return fmt.Errorf("setting SO_SNDBUF to %d: %w", s.SendBuffer, err)
+		}
+	}
+	if s.ReceiveBuffer > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, s.ReceiveBuffer); err != nil {
+			return fmt.Errorf("setting SO_RCVBUF to %d: %w", s.ReceiveBuffer, err)
+		}
+	}
+
+	tcpOpts := s
+	tcpOpts.SendBuffer, tcpOpts.ReceiveBuffer = 0, 0
+	if tcpOpts == (SocketOptions{}) {
+		return nil
+	}
+	if !isTCP(network) {
+		return fmt.Errorf("tcp socket options cannot be set on %s sockets", network)
+	}
+
+	if s.NoDelay != nil {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, boolInt(*s.NoDelay)); err != nil {
+			return fmt.Errorf("setting TCP_NODELAY: %w", err)
+		}
+	}
+	if s.Cork {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_CORK, 1); err != nil {
+			return fmt.Errorf("setting TCP_CORK: %w", err)
+		}
+	}
+	if s.Congestion != "" {
+		if err := unix.SetsockoptString(fd, unix.IPPROTO_TCP, unix.TCP_CONGESTION, s.Congestion); err != nil {
+			if errors.Is(err, unix.ENOENT) {
+				return fmt.Errorf("congestion control %s is not available, see net.ipv4.tcp_available_congestion_control", s.Congestion)
+			}
+			return fmt.Errorf("setting TCP_CONGESTION to %s: %w", s.Congestion, err)
+		}
+	}
+	if s.MaxSegment > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_MAXSEG, s.MaxSegment); err != nil {
+			return fmt.Errorf("setting TCP_MAXSEG to %d: %w", s.MaxSegment, err)
+		}
+	}
+	if s.NotSentLowat > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT, s.NotSentLowat); err != nil {
+			return fmt.Errorf("setting TCP_NOTSENT_LOWAT to %d: %w", s.NotSentLowat, err)
+		}
+	}
+	return nil
+}
+
+// getSocketSettings returns the options in effect on the socket fd of
+// network. Options the kernel does not report are left out.
+func getSocketSettings(fd int, network string) *SocketSettings {
+	s := &SocketSettings{}
+	s.SendBuffer, _ = unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF)
+	s.ReceiveBuffer, _ = unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF)
+	if !isTCP(network) {
+		return s
+	}
+
+	noDelay, _ := unix.GetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY)
+	cork, _ := unix.GetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_CORK)
+	s.NoDelay, s.Cork = noDelay != 0, cork != 0
+	// the name is padded with NULs to TCP_CA_NAME_MAX
+	congestion, _ := unix.GetsockoptString(fd, unix.IPPROTO_TCP, unix.TCP_CONGESTION)
+	s.Congestion = strings.TrimRight(congestion, "\x00")
+	s.MaxSegment, _ = unix.GetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_MAXSEG)
+	s.NotSentLowat, _ = unix.GetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT)
+	return s
+}
@@ -0,0 +1,195 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSocketOptions(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	noDelay := false
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	o.NumMsg = 100
+	o.Socket = &SocketOptions{
+		SendBuffer:    64 * 1024,
+		ReceiveBuffer: 64 * 1024,
+		NoDelay:       &noDelay,
+		Congestion:    "reno",
+		MaxSegment:    1000,
+		NotSentLowat:  16 * 1024,
+	}
+
+	l, err := o.Listen()
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	server := make(chan *SocketSettings, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			server <- nil
+			return
+		}
+		defer conn.Close()
+		server <- socketSettings(conn)
+		desc, err := readTest(context.Background(), conn)
+		if err == nil {
+			_ = o.LatencyServer().runTest(context.Background(), conn, desc)
+		}
+	}()
+
+	conn, err := o.Dial()
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	result, err := o.LatencyClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+
+	for side, s := range map[string]*SocketSettings{"client": result.Socket, "server": <-server} {
+		if s == nil {
+			t.Errorf("expected %s socket settings", side)
+			continue
+		}
+		// Linux doubles the buffer sizes
+		if s.SendBuffer != 2*o.Socket.SendBuffer || s.ReceiveBuffer != 2*o.Socket.ReceiveBuffer {
+			t.Errorf("expected %s buffers of %d bytes, got %+v", side, 2*o.Socket.SendBuffer, s)
+		}
+		if s.NoDelay || s.Congestion != "reno" || s.NotSentLowat != o.Socket.NotSentLowat || s.MaxSegment > o.Socket.MaxSegment {
+			t.Errorf("expected %s socket options %+v to be in effect, got %+v", side, o.Socket, s)
+		}
+	}
+}
+
+func TestSocketOptionsRejected(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	o.Socket = &SocketOptions{Congestion: "benchmate"}
+	if _, err := o.Dial(); err == nil {
+		t.Error("expected unknown congestion control to be rejected")
+	}
+
+	o.Network = "udp"
+	o.Socket = &SocketOptions{Cork: true}
+	if _, err := o.Dial(); err == nil {
+		t.Error("expected tcp options on udp socket to be rejected")
+	}
+}
+
+func TestSocketOptionsContext(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	dir, err := ioutil.TempDir("", "benchmate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverTLS, _ := writeCerts(t, dir)
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	o.TLS = &serverTLS
+	o.Socket = &SocketOptions{SendBuffer: 64 * 1024}
+
+	l, err := o.Listen()
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- o.Server().ServeContext(ctx, l)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected server to stop once the context is done")
+	}
+
+	// the deadline stopped the server, the listener is still usable
+	go func() {
+		conn, err := net.Dial(o.Network, o.Addr)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("expected listener to accept connections, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestSocketOptionsContextNoDeadline(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	tl := tunedListener{Listener: noDeadlineListener{l}, socket: &SocketOptions{SendBuffer: 64 * 1024}}
+	defer tl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- o.Server().ServeContext(ctx, tl)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected server to stop once the context is done")
+	}
+}
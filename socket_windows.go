@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// setSocketOptions sets the options s on the socket fd of network. Windows
+// does not support setting TCP_MAXSEG, TCP_CORK, TCP_CONGESTION and
+// TCP_NOTSENT_LOWAT.
+func setSocketOptions(fd int, network string, s SocketOptions) error {
+	if s.MaxSegment > 0 {
+		return errors.New("TCP_MAXSEG is not supported on windows")
+	}
+	if s.Cork || s.Congestion != "" || s.NotSentLowat > 0 {
+		return errors.New("TCP_CORK, TCP_CONGESTION and TCP_NOTSENT_LOWAT are only supported on linux")
+	}
+	h := windows.Handle(fd)
+	if s.SendBuffer > 0 {
+		if err := windows.SetsockoptInt(h, windows.SOL_SOCKET, windows.SO_SNDBUF, s.SendBuffer); err != nil {
+			return fmt.Errorf("setting SO_SNDBUF to %d: %w", s.SendBuffer, err)
+		}
+	}
+	if s.ReceiveBuffer > 0 {
+		if err := windows.SetsockoptInt(h, windows.SOL_SOCKET, windows.SO_RCVBUF, s.ReceiveBuffer); err != nil {
+			return fmt.Errorf("setting SO_RCVBUF to %d: %w", s.ReceiveBuffer, err)
+		}
+	}
+
+	if s.NoDelay == nil {
+		return nil
+	}
+	if !isTCP(network) {
+		return fmt.Errorf("tcp socket options cannot be set on %s sockets", network)
+	}
+	if err := windows.SetsockoptInt(h, windows.IPPROTO_TCP, windows.TCP_NODELAY, boolInt(*s.NoDelay)); err != nil {
+		return fmt.Errorf("setting TCP_NODELAY: %w", err)
+	}
+	return nil
+}
+
+// getSocketSettings returns the options in effect on the socket fd of
+// network. Options the kernel does not report are left out.
+func getSocketSettings(fd int, network string) *SocketSettings {
+	h := windows.Handle(fd)
+	s := &SocketSettings{}
+	s.SendBuffer, _ = windows.GetsockoptInt(h, windows.SOL_SOCKET, windows.SO_SNDBUF)
+	s.ReceiveBuffer, _ = windows.GetsockoptInt(h, windows.SOL_SOCKET, windows.SO_RCVBUF)
+	if !isTCP(network) {
+		return s
+	}
+
+	noDelay, _ := windows.GetsockoptInt(h, windows.IPPROTO_TCP, windows.TCP_NODELAY)
+	s.NoDelay = noDelay != 0
+	return s
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// histogramSubBuckets is the number of linear buckets each power of two
+// range is split into. 16 sub-buckets keep the relative error of a bucket
+// below 6.25%.
+const histogramSubBuckets = 16
+
+// LatencyStats summarizes the round-trip times measured during a latency run.
+type LatencyStats struct {
+	Min       time.Duration     `json:"min"`                 // smallest round-trip time
+	Max       time.Duration     `json:"max"`                 // largest round-trip time
+	Mean      time.Duration     `json:"mean"`                // mean round-trip time
+	StdDev    time.Duration     `json:"stdDev"`              // standard deviation of round-trip times
+	P50       time.Duration     `json:"p50"`                 // median round-trip time
+	P90       time.Duration     `json:"p90"`                 // 90th percentile round-trip time
+	P99       time.Duration     `json:"p99"`                 // 99th percentile round-trip time
+	P999      time.Duration     `json:"p999"`                // 99.9th percentile round-trip time
+	Histogram []HistogramBucket `json:"histogram,omitempty"` // non-empty buckets in increasing order
+
+	Jitter             time.Duration `json:"jitter"`             // interarrival jitter as defined in RFC 3550
+	MeanDelayVariation time.Duration `json:"meanDelayVariation"` // mean absolute difference between consecutive samples
+}
+
+// HistogramBucket counts the round-trip times in the range [From, To).
+type HistogramBucket struct {
+	From  time.Duration `json:"from"`
+	To    time.Duration `json:"to"`
+	Count int           `json:"count"`
+}
+
+// NewLatencyStats computes LatencyStats from round-trip time samples.
+// Percentiles use the nearest-rank method. The histogram uses log-linear
+// buckets like HDR histograms: every power of two range is divided into
+// 16 equally sized buckets. Samples must be in the order they were taken
+// since jitter depends on the difference between consecutive samples.
+func NewLatencyStats(samples []time.Duration) LatencyStats {
+	if len(samples) == 0 {
+		return LatencyStats{}
+	}
+
+	// RFC 3550 section 6.4.1: J(i) = J(i-1) + (|D(i-1,i)| - J(i-1))/16
+	var jitter, diffsum float64
+	for i := 1; i < len(samples); i++ {
+		d := math.Abs(float64(samples[i] - samples[i-1]))
+		jitter += (d - jitter) / 16
+		diffsum += d
+	}
+	var mdv float64
+	if len(samples) > 1 {
+		mdv = diffsum / float64(len(samples)-1)
+	}
+
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum float64
+	for _, s := range sorted {
+		sum += float64(s)
+	}
+	mean := sum / float64(len(sorted))
+
+	var sqsum float64
+	for _, s := range sorted {
+		d := float64(s) - mean
+		sqsum += d * d
+	}
+
+	return LatencyStats{
+		Min:       sorted[0],
+		Max:       sorted[len(sorted)-1],
+		Mean:      time.Duration(mean),
+		StdDev:    time.Duration(math.Sqrt(sqsum / float64(len(sorted)))),
+		P50:       percentile(sorted, 50),
+		P90:       percentile(sorted, 90),
+		P99:       percentile(sorted, 99),
+		P999:      percentile(sorted, 99.9),
+		Histogram: histogram(sorted),
+
+		Jitter:             time.Duration(jitter),
+		MeanDelayVariation: time.Duration(mdv),
+	}
+}
+
+// percentile returns the p-th percentile of sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	// the epsilon keeps floating point error from bumping exact ranks,
+	// e.g. 99.9% of 1000 samples, to the next sample
+	rank := int(math.Ceil(p/100*float64(len(sorted)) - 1e-9))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// histogram groups sorted samples into log-linear buckets.
+func histogram(sorted []time.Duration) []HistogramBucket {
+	var buckets []HistogramBucket
+	for _, s := range sorted {
+		from, to := bucketBounds(s)
+		if n := len(buckets); n > 0 && buckets[n-1].From == from {
+			buckets[n-1].Count++
+			continue
+		}
+		buckets = append(buckets, HistogramBucket{From: from, To: to, Count: 1})
+	}
+	return buckets
+}
+
+// bucketBounds returns the bounds of the histogram bucket containing d.
+func bucketBounds(d time.Duration) (time.Duration, time.Duration) {
+	if d < histogramSubBuckets {
+		return d, d + 1
+	}
+	shift := bits.Len64(uint64(d)) - bits.Len64(histogramSubBuckets)
+	from := d >> shift << shift
+	return from, from + 1<<shift
+}
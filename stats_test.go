@@ -0,0 +1,104 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	var samples []time.Duration
+	for i := 1000; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Microsecond)
+	}
+
+	s := NewLatencyStats(samples)
+
+	tests := []struct {
+		name     string
+		got      time.Duration
+		expected time.Duration
+	}{
+		{"min", s.Min, time.Microsecond},
+		{"max", s.Max, 1000 * time.Microsecond},
+		{"mean", s.Mean, 500500 * time.Nanosecond},
+		{"p50", s.P50, 500 * time.Microsecond},
+		{"p90", s.P90, 900 * time.Microsecond},
+		{"p99", s.P99, 990 * time.Microsecond},
+		{"p99.9", s.P999, 999 * time.Microsecond},
+	}
+	for _, test := range tests {
+		if test.got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, test.got)
+		}
+	}
+
+	if s.StdDev < 288*time.Microsecond || s.StdDev > 289*time.Microsecond {
+		t.Errorf("stddev: expected ~288.7µs, got %v", s.StdDev)
+	}
+
+	total := 0
+	for i, b := range s.Histogram {
+		total += b.Count
+		if b.From >= b.To {
+			t.Errorf("bucket %d: bad bounds [%v, %v)", i, b.From, b.To)
+		}
+		if i > 0 && s.Histogram[i-1].To > b.From {
+			t.Errorf("bucket %d: overlaps previous bucket", i)
+		}
+		if width := b.To - b.From; width > b.From/histogramSubBuckets*2 {
+			t.Errorf("bucket %d: width %v too large for %v", i, width, b.From)
+		}
+	}
+	if total != len(samples) {
+		t.Errorf("histogram: expected %d samples, got %d", len(samples), total)
+	}
+
+	if samples[0] != 1000*time.Microsecond {
+		t.Error("samples were modified")
+	}
+}
+
+func TestLatencyStatsJitter(t *testing.T) {
+	// constant round-trip times have no variation
+	s := NewLatencyStats([]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond})
+	if s.Jitter != 0 || s.MeanDelayVariation != 0 {
+		t.Errorf("expected no jitter, got jitter %v, variation %v", s.Jitter, s.MeanDelayVariation)
+	}
+
+	// alternating between 1ms and 2ms
+	var samples []time.Duration
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, time.Duration(1+i%2)*time.Millisecond)
+	}
+	s = NewLatencyStats(samples)
+	if s.MeanDelayVariation != time.Millisecond {
+		t.Errorf("expected variation 1ms, got %v", s.MeanDelayVariation)
+	}
+	// the RFC 3550 estimator converges to the mean difference
+	if s.Jitter < 999*time.Microsecond || s.Jitter > time.Millisecond {
+		t.Errorf("expected jitter ~1ms, got %v", s.Jitter)
+	}
+}
+
+func TestLatencyStatsEmpty(t *testing.T) {
+	s := NewLatencyStats(nil)
+	if s.Max != 0 || s.Histogram != nil {
+		t.Errorf("expected zero stats, got %+v", s)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Default range of message sizes of a sweep.
+const (
+	DefaultSweepMinSize = 64
+	DefaultSweepMaxSize = 4 * 1024 * 1024
+)
+
+// DialFunc connects to a server. Clients running more than one test, like
+// SweepClient, use it to get a new connection for every test.
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
+// SweepResult contains the results of the tests with one message size of
+// a sweep.
+type SweepResult struct {
+	MsgSize    int               `json:"msgSize"`              // size of messages in bytes
+	Latency    *LatencyResult    `json:"latency,omitempty"`    // result of the latency test
+	Throughput *ThroughputResult `json:"throughput,omitempty"` // result of the throughput test
+}
+
+// SweepClient runs latency and throughput tests with message sizes in
+// powers of two, to show how the message size affects the performance,
+// e.g. where it crosses the MTU.
+type SweepClient struct {
+	minSize    int
+	maxSize    int
+	latency    *LatencyClient
+	throughput *ThroughputClient
+	onResult   func(SweepResult)
+}
+
+// NewSweepClient returns an instance of SweepClient for message sizes from
+// minSize to maxSize, doubling the size every step. Add the tests to run
+// with WithLatency and WithThroughput.
+func NewSweepClient(minSize, maxSize int) SweepClient {
+	return SweepClient{
+		minSize: minSize,
+		maxSize: maxSize,
+	}
+}
+
+// WithLatency returns a copy of the client that runs a latency test like c
+// with every message size.
+func (s SweepClient) WithLatency(c LatencyClient) SweepClient {
+	s.latency = &c
+	return s
+}
+
+// WithThroughput returns a copy of the client that runs a throughput test
+// like c with every message size. Configure c with a duration, otherwise
+// the large sizes take long to send the configured number of messages.
+func (s SweepClient) WithThroughput(c ThroughputClient) SweepClient {
+	s.throughput = &c
+	return s
+}
+
+// OnResult returns a copy of the client that calls fn with the results of
+// every message size once its tests are done. fn is called from the
+// goroutine calling Run.
+func (s SweepClient) OnResult(fn func(SweepResult)) SweepClient {
+	s.onResult = fn
+	return s
+}
+
+// Sizes returns the message sizes of the sweep.
+func (s SweepClient) Sizes() []int {
+	var sizes []int
+	for size := s.minSize; size > 0 && size <= s.maxSize; size *= 2 {
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
+
+// Run runs the tests with every message size, each over a new connection
+// returned by dial, and returns the results ordered by message size. The
+// server has to serve the tests one after another, e.g. a Server in daemon
+// mode. Over datagram sockets the sweep ends with the largest size that
+// fits in a datagram.
+//
+// If a test fails, the results of the sizes done until then are returned
+// along with the error.
+func (s SweepClient) Run(dial DialFunc) ([]SweepResult, error) {
+	return s.RunContext(context.Background(), dial)
+}
+
+// RunContext is like Run but aborts the sweep once ctx is done, see
+// LatencyClient.RunContext and ThroughputClient.RunContext.
+func (s SweepClient) RunContext(ctx context.Context, dial DialFunc) ([]SweepResult, error) {
+	if s.latency == nil && s.throughput == nil {
+		return nil, errors.New("no tests to sweep")
+	}
+	sizes := s.Sizes()
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("no message sizes from %d to %d", s.minSize, s.maxSize)
+	}
+
+	var results []SweepResult
+	for _, size := range sizes {
+		result := SweepResult{MsgSize: size}
+		if s.latency != nil {
+			lm := *s.latency
+			lm.msgSize = size
+			fits, err := s.run(ctx, dial, size, func(conn net.Conn) (err error) {
+				result.Latency, err = lm.RunContext(ctx, conn)
+				return err
+			})
+			if err != nil || !fits {
+				return results, err
+			}
+		}
+		if s.throughput != nil {
+			c := *s.throughput
+			c.msgSize = size
+			fits, err := s.run(ctx, dial, size, func(conn net.Conn) (err error) {
+				result.Throughput, err = c.RunContext(ctx, conn)
+				return err
+			})
+			if err != nil || !fits {
+				return results, err
+			}
+		}
+
+		results = append(results, result)
+		if s.onResult != nil {
+			s.onResult(result)
+		}
+	}
+	return results, nil
+}
+
+// run runs a test with message size size over a new connection. It returns
+// false without running the test if the size does not fit in a datagram.
+func (s SweepClient) run(ctx context.Context, dial DialFunc, size int, test func(net.Conn) error) (bool, error) {
+	conn, err := dial(ctx)
+	if err != nil {
+		return false, fmt.Errorf("message size %d: %w", size, err)
+	}
+	defer conn.Close()
+
+	if isDatagram(conn) && size > maxDatagramSize {
+		return false, nil
+	}
+	if err := test(conn); err != nil {
+		return false, fmt.Errorf("message size %d: %w", size, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	lo := DefaultLatencyOptions()
+	lo.NumMsg = 10
+	lo.Addr = fmt.Sprintf(":%d", randPort())
+	to := DefaultThroughputOptions()
+	to.Duration = 100
+
+	l, err := net.Listen(lo.Network, lo.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		_ = NewServer().Serve(l)
+	}()
+
+	var reported []int
+	s := NewSweepClient(64, 1000).WithLatency(lo.LatencyClient()).WithThroughput(to.ThroughputClient())
+	results, err := s.OnResult(func(r SweepResult) {
+		reported = append(reported, r.MsgSize)
+	}).Run(func(ctx context.Context) (net.Conn, error) {
+		return net.Dial(lo.Network, lo.Addr)
+	})
+	if err != nil {
+		t.Fatalf("Error running sweep: %v", err)
+	}
+
+	sizes := []int{64, 128, 256, 512}
+	if len(results) != len(sizes) || len(reported) != len(sizes) {
+		t.Fatalf("expected results for sizes %v, got %d results, %v reported", sizes, len(results), reported)
+	}
+	for i, r := range results {
+		if r.MsgSize != sizes[i] || r.Latency == nil || r.Throughput == nil {
+			t.Fatalf("expected latency and throughput with message size %d, got %+v", sizes[i], r)
+		}
+		if len(r.Latency.Samples) != lo.NumMsg || r.Throughput.MsgSize != sizes[i] {
+			t.Errorf("message size %d: expected %d pings and messages of the size, got %d pings and %d bytes messages", sizes[i], lo.NumMsg, len(r.Latency.Samples), r.Throughput.MsgSize)
+		}
+	}
+}
+
+func TestSweepDatagram(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Network = "udp"
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	o.NumMsg = 10
+
+	pc, err := net.ListenPacket(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	l := NewPacketListener(pc)
+	defer l.Close()
+	go func() {
+		_ = NewServer().Serve(l)
+	}()
+
+	// the sweep ends with the largest size that fits in a datagram
+	results, err := NewSweepClient(16*1024, 256*1024).WithLatency(o.LatencyClient()).Run(func(ctx context.Context) (net.Conn, error) {
+		return net.Dial(o.Network, o.Addr)
+	})
+	if err != nil {
+		t.Fatalf("Error running sweep: %v", err)
+	}
+	if len(results) != 2 || results[1].MsgSize != 32*1024 || results[1].Throughput != nil {
+		t.Errorf("expected latency results for 16 and 32 KiB, got %+v", results)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"net"
+	"time"
+)
+
+// TCPInfo holds the statistics the kernel keeps about a TCP connection,
+// read with TCP_INFO at the end of a run on Linux. A flow that retransmits
+// and keeps a small congestion window is limited by loss, one with a large
+// window and no retransmits by the window of the receiver or the sender.
+type TCPInfo struct {
+	RTT          time.Duration `json:"rtt"`                    // smoothed round-trip time
+	RTTVar       time.Duration `json:"rttVar"`                 // mean deviation of the round-trip time
+	Retransmits  int           `json:"retransmits"`            // number of segments retransmitted during the connection
+	Lost         int           `json:"lost"`                   // number of segments considered lost at the end of the run
+	Cwnd         int           `json:"cwnd"`                   // congestion window in segments
+	Ssthresh     int           `json:"ssthresh,omitempty"`     // slow start threshold in segments, 0 if the connection never left slow start
+	PacingRate   int64         `json:"pacingRate,omitempty"`   // pacing rate in bytes/s, 0 if the connection is not paced
+	DeliveryRate int64         `json:"deliveryRate,omitempty"` // most recent delivery rate in bytes/s
+	Reordering   int           `json:"reordering"`             // number of segments a segment may be reordered by before it counts as lost
+}
+
+// tcpInfo returns the TCP_INFO statistics of conn, or nil if conn is not
+// a TCP connection or the platform does not report them.
+func tcpInfo(conn net.Conn) *TCPInfo {
+	if addr := conn.LocalAddr(); addr == nil || !isTCP(addr.Network()) {
+		return nil
+	}
+	c, err := rawConn(conn)
+	if err != nil {
+		return nil
+	}
+
+	var info *TCPInfo
+	if err := c.Control(func(fd uintptr) {
+		info = getTCPInfo(int(fd))
+	}); err != nil {
+		return nil
+	}
+	return info
+}
@@ -0,0 +1,77 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// rawTCPInfo is struct tcp_info of linux/tcp.h up to tcpi_delivery_rate.
+// unix.TCPInfo ends before the rates.
+type rawTCPInfo struct {
+	unix.TCPInfo
+	PacingRate    uint64
+	MaxPacingRate uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+	SegsOut       uint32
+	SegsIn        uint32
+	NotsentBytes  uint32
+	MinRTT        uint32
+	DataSegsIn    uint32
+	DataSegsOut   uint32
+	DeliveryRate  uint64
+}
+
+// infiniteSsthresh is the slow start threshold of connections that never
+// left slow start, TCP_INFINITE_SSTHRESH.
+const infiniteSsthresh = 0x7fffffff
+
+// getTCPInfo returns the TCP_INFO statistics of the socket fd. Kernels
+// older than 4.9 do not report the rates, they are 0 then.
+func getTCPInfo(fd int) *TCPInfo {
+	var raw rawTCPInfo
+	size := uint32(unsafe.Sizeof(raw))
+	if _, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.IPPROTO_TCP, unix.TCP_INFO,
+		uintptr(unsafe.Pointer(&raw)), uintptr(unsafe.Pointer(&size)), 0); errno != 0 {
+		return nil
+	}
+
+	info := &TCPInfo{
+		RTT:          time.Duration(raw.Rtt) * time.Microsecond,
+		RTTVar:       time.Duration(raw.Rttvar) * time.Microsecond,
+		Retransmits:  int(raw.Total_retrans),
+		Lost:         int(raw.Lost),
+		Cwnd:         int(raw.Snd_cwnd),
+		Reordering:   int(raw.Reordering),
+		DeliveryRate: int64(raw.DeliveryRate),
+	}
+	if raw.Snd_ssthresh < infiniteSsthresh {
+		info.Ssthresh = int(raw.Snd_ssthresh)
+	}
+	// unpaced connections report ^0
+	if raw.PacingRate != ^uint64(0) {
+		info.PacingRate = int64(raw.PacingRate)
+	}
+	return info
+}
@@ -0,0 +1,93 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestTCPInfo(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	// struct tcp_info up to tcpi_delivery_rate
+	if size := unsafe.Sizeof(rawTCPInfo{}); size != 168 {
+		t.Fatalf("expected tcp_info of 168 bytes, got %d", size)
+	}
+
+	o := DefaultThroughputOptions()
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	o.NumMsg = 1000
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		_ = NewServer().Serve(l)
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	tp, err := o.ThroughputClient().Run(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+	if info := tp.TCPInfo; info == nil || info.RTT <= 0 || info.Cwnd <= 0 || info.DeliveryRate <= 0 {
+		t.Errorf("expected tcp info with round-trip time, congestion window and delivery rate, got %+v", info)
+	}
+
+	o = DefaultLatencyOptions()
+	o.Addr = l.Addr().String()
+	o.NumMsg = 100
+	conn, err = net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	lat, err := o.LatencyClient().Run(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+	if info := lat.TCPInfo; info == nil || info.RTT <= 0 || info.Cwnd <= 0 {
+		t.Errorf("expected tcp info with round-trip time and congestion window, got %+v", info)
+	}
+	t.Logf("%+v", lat.TCPInfo)
+}
+
+func TestTCPInfoUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error making socket: %v", err)
+	}
+	defer conn.Close()
+
+	if info := tcpInfo(conn.(*net.UDPConn)); info != nil {
+		t.Errorf("expected no tcp info of udp socket, got %+v", info)
+	}
+}
@@ -17,34 +17,317 @@ limitations under the License.
 package benchmate
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
+	"os"
+	"sync"
 	"time"
 )
 
+// Directions of a throughput run.
+const (
+	DirectionForward = "forward" // the client sends, the server receives
+	DirectionReverse = "reverse" // the server sends, the client receives
+	DirectionBidir   = "bidir"   // both send and receive at the same time
+)
+
 // ThroughputResult contains the details of a throughput estimation run.
-// AvgThroughput = MsgSize * NumMsg / Elapsed in MB/s.
+// AvgThroughput = Bytes / Elapsed in MB/s.
+//
+// The sender only measures how fast data goes into its socket buffers. The
+// top level fields hold what the side calling Run measured. The receiver
+// fields hold what the receiving side measured from the first byte
+// to the end of the stream. They are empty if the receiver could not report
+// back, e.g. because the connection does not support closing only the write
+// side.
+//
+// In runs with parallel streams the top level fields sum up all streams,
+// the elapsed time is the one of the slowest stream.
+//
+// In bidirectional runs the top level fields sum up both directions, and
+// Upstream (client to server) and Downstream (server to client) hold what
+// the side calling Run measured for each direction. Peers cannot report
+// back in bidirectional runs, so only the receiving direction has receiver
+// fields.
+//
+// In runs over datagram sockets, e.g. UDP, UDP holds the loss, reordering
+// and jitter of the datagrams seen by the receiver.
 type ThroughputResult struct {
-	MsgSize       int           `json:"msgSize"`       // size of a message in bytes
-	NumMsg        int           `json:"numMsg"`        // number of messages received from the client
-	Elapsed       time.Duration `json:"elapsed"`       // total time
-	AvgThroughput float64       `json:"avgThroughput"` // avg throughput in MB/s
+	MsgSize       int             `json:"msgSize"`                 // size of a message in bytes
+	NumMsg        int             `json:"numMsg"`                  // number of messages received from the client
+	Bytes         int64           `json:"bytes"`                   // number of bytes transferred, including partially sent messages
+	Elapsed       time.Duration   `json:"elapsed"`                 // total time
+	AvgThroughput float64         `json:"avgThroughput"`           // avg throughput in MB/s
+	HandshakeTime time.Duration   `json:"handshakeTime,omitempty"` // time the TLS handshake took, the longest one of parallel streams
+	Socket        *SocketSettings `json:"socket,omitempty"`        // options in effect on the socket of the client, the one of the first of parallel streams
+	TCPInfo       *TCPInfo        `json:"tcpInfo,omitempty"`       // kernel statistics of the connection of the client at the end of the run, Linux only, per stream in runs with parallel streams
+
+	ReceivedBytes      int64         `json:"receivedBytes,omitempty"`      // number of bytes read by the receiver
+	ReceiverElapsed    time.Duration `json:"receiverElapsed,omitempty"`    // time from the first byte to the end of the stream at the receiver
+	ReceiverThroughput float64       `json:"receiverThroughput,omitempty"` // avg throughput at the receiver in MB/s
+
+	Direction  string             `json:"direction,omitempty"`  // direction of the run, see DirectionForward, DirectionReverse and DirectionBidir
+	Upstream   *ThroughputResult  `json:"upstream,omitempty"`   // client to server direction of a bidirectional run
+	Downstream *ThroughputResult  `json:"downstream,omitempty"` // server to client direction of a bidirectional run
+	Start      time.Duration      `json:"start,omitempty"`      // offset of an interval from the start of the run
+	Intervals  []ThroughputResult `json:"intervals,omitempty"`  // per interval results if interval reporting is enabled
+	Stream     int                `json:"stream,omitempty"`     // index of the stream, starting at 1, in runs with parallel streams
+	Streams    []ThroughputResult `json:"streams,omitempty"`    // per stream results in runs with parallel streams
+	UDP        *UDPStats          `json:"udp,omitempty"`        // datagram statistics of runs over datagram sockets
+}
+
+// throughputConfig holds the parameters of a throughput run that are
+// shared by servers and clients.
+type throughputConfig struct {
+	msgSize    int
+	numMsg     int
+	timeout    int
+	duration   int
+	interval   int
+	onInterval func(ThroughputResult)
+	direction  string
+	parallel   int
+	bitrate    int64
+}
+
+// check returns an error if the configuration cannot be used for runs
+// over conn.
+func (c throughputConfig) check(conn net.Conn) error {
+	if err := c.checkDirection(); err != nil {
+		return err
+	}
+	if isDatagram(conn) {
+		return c.checkDatagram()
+	}
+	return nil
+}
+
+// checkDirection returns an error if the configured direction is unknown.
+func (c throughputConfig) checkDirection() error {
+	switch c.direction {
+	case "", DirectionForward, DirectionReverse, DirectionBidir:
+		return nil
+	default:
+		return fmt.Errorf("unknown direction %q", c.direction)
+	}
+}
+
+// run runs the client or the server side of a throughput run over conn.
+func (c throughputConfig) run(ctx context.Context, conn net.Conn, client bool) (*ThroughputResult, error) {
+	if err := c.checkDirection(); err != nil {
+		return nil, err
+	}
+
+	d := watch(ctx, conn)
+	defer d.stop()
+
+	switch {
+	case isDatagram(conn) && client:
+		return c.runPacketSender(d)
+	case isDatagram(conn):
+		return c.receivePackets(d)
+	case c.direction == DirectionBidir:
+		return c.runBidir(d, client)
+	case (c.direction == DirectionReverse) == client:
+		return c.runReceiver(d)
+	default:
+		return c.runSender(d)
+	}
+}
+
+// descriptor returns the test descriptor a client sends to the server.
+func (c throughputConfig) descriptor() TestDescriptor {
+	return TestDescriptor{
+		Type:      TestTypeThroughput,
+		MsgSize:   c.msgSize,
+		NumMsg:    c.numMsg,
+		Timeout:   c.timeout,
+		Duration:  c.duration,
+		Direction: c.direction,
+		Bitrate:   c.bitrate,
+		Streams:   c.streams(),
+	}
+}
+
+// configure returns the configuration of a server for the test described
+// by desc. Only the interval is kept from the server's configuration.
+func (c throughputConfig) configure(desc TestDescriptor) (throughputConfig, error) {
+	if err := checkTest(desc, TestTypeThroughput); err != nil {
+		return c, err
+	}
+
+	c.msgSize = desc.MsgSize
+	c.numMsg = desc.NumMsg
+	c.timeout = desc.Timeout
+	c.duration = desc.Duration
+	c.direction = desc.Direction
+	c.bitrate = desc.Bitrate
+	c.parallel = desc.Streams
+	if c.msgSize*c.streams() > maxMsgSize {
+		return c, fmt.Errorf("messages of %d parallel streams of %d bytes exceed %d bytes", c.streams(), c.msgSize, maxMsgSize)
+	}
+	return c, c.checkDirection()
+}
+
+// streams returns the number of parallel streams.
+func (c throughputConfig) streams() int {
+	if c.parallel < 1 {
+		return 1
+	}
+	return c.parallel
+}
+
+// runStreams runs one stream per connection returned by next in parallel
+// and aggregates the results. Connections are closed when their stream is
+// done if closeConns is set.
+func (c throughputConfig) runStreams(ctx context.Context, next func() (net.Conn, error), client, closeConns bool) (*ThroughputResult, error) {
+	n := c.streams()
+	results := make([]*ThroughputResult, n)
+	errs := make([]error, n)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		conn, err := next()
+		if err != nil {
+			errs[i] = err
+			break
+		}
+
+		sc := c
+		sc.bitrate = c.bitrate / int64(n)
+		if c.bitrate > 0 && sc.bitrate == 0 {
+			// a bitrate of 0 would not limit the stream at all
+			sc.bitrate = 1
+		}
+		if c.onInterval != nil {
+			stream := i + 1
+			sc.onInterval = func(r ThroughputResult) {
+				mu.Lock()
+				defer mu.Unlock()
+				r.Stream = stream
+				c.onInterval(r)
+			}
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if closeConns {
+				defer conn.Close()
+			}
+			results[i], errs[i] = sc.run(ctx, conn, client)
+		}(i)
+	}
+	wg.Wait()
+
+	var done []*ThroughputResult
+	for _, r := range results {
+		if r != nil {
+			done = append(done, r)
+		}
+	}
+	var result *ThroughputResult
+	if len(done) > 0 {
+		result = aggregate(done)
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return result, fmt.Errorf("stream %d: %w", i+1, err)
+		}
+	}
+
+	return result, nil
+}
+
+// aggregate sums up the results of parallel streams.
+func aggregate(streams []*ThroughputResult) *ThroughputResult {
+	var bytes, received int64
+	var elapsed, receiverElapsed time.Duration
+	var ups, downs []*ThroughputResult
+	for _, r := range streams {
+		bytes += r.Bytes
+		received += r.ReceivedBytes
+		if r.Elapsed > elapsed {
+			elapsed = r.Elapsed
+		}
+		if r.ReceiverElapsed > receiverElapsed {
+			receiverElapsed = r.ReceiverElapsed
+		}
+		if r.Upstream != nil && r.Downstream != nil {
+			ups = append(ups, r.Upstream)
+			downs = append(downs, r.Downstream)
+		}
+	}
+
+	result := newThroughputResult(streams[0].MsgSize, bytes, elapsed)
+	if received > 0 {
+		result.setReceiver(received, receiverElapsed)
+	}
+	result.Direction = streams[0].Direction
+	if len(ups) == len(streams) {
+		result.Upstream, result.Downstream = aggregate(ups), aggregate(downs)
+		result.Upstream.Direction, result.Downstream.Direction = DirectionForward, DirectionReverse
+	}
+	for i, r := range streams {
+		r.Stream = i + 1
+		result.Streams = append(result.Streams, *r)
+	}
+
+	return result
+}
+
+// throughputOutcome returns the result of a run that ended with err. If
+// the run was interrupted because ctx is done, the partial result is
+// returned along with an error wrapping the context error.
+func throughputOutcome(ctx context.Context, result *ThroughputResult, err error) (*ThroughputResult, error) {
+	if err == nil {
+		return result, nil
+	}
+	if ctxErr := interrupted(ctx, err); ctxErr != nil {
+		return result, ctxErr
+	}
+	return nil, err
 }
 
 // ThroughputServer holds parameters for the server side of throughput estimation.
 type ThroughputServer struct {
-	msgSize int
+	throughputConfig
+	concurrent bool
 }
 
-// NewThroughputServer creates a new instance of ThroughputServer.
+// NewThroughputServer creates a new instance of ThroughputServer. The
+// message size is only a default, clients send theirs in the test
+// descriptor.
 func NewThroughputServer(msgSize int) ThroughputServer {
 	return ThroughputServer{
-		msgSize: msgSize,
+		throughputConfig: throughputConfig{
+			msgSize: msgSize,
+		},
 	}
 }
 
-// Run waits to get connection from a client. It then reads all the data sent by
-// the client over the connection and returns when client closes the connection.
+// Run waits to get connection from a client. It then reads the test
+// descriptor sent by the client and configures itself from it. In the
+// default forward direction it reads all the data sent by the client over
+// the connection until the client closes its side of the connection. The
+// number of bytes received and the time from the first byte to the end of
+// the stream are sent back to the client, RunContext returns them on the
+// server as well.
+//
+// In reverse direction the server sends the messages and the client
+// reports back what it received. In bidirectional mode both do at the
+// same time.
+//
+// If the client runs parallel streams, the server accepts one connection
+// per stream and returns the aggregated result once all streams are done.
+// Connections of other clients are rejected in the meantime.
 //
 // It accepts a listener. The following code will run the server at port 8888.
 //
@@ -56,72 +339,582 @@ func NewThroughputServer(msgSize int) ThroughputServer {
 //	l, _ := net.Listen("unix", "/tmp/tp-srv")
 //	s.Run(l)
 func (s ThroughputServer) Run(l net.Listener) error {
-	conn, err := l.Accept()
+	_, err := s.RunContext(context.Background(), l)
+	return err
+}
+
+// RunContext is like Run but returns the result of the test as the server
+// measured it. It stops waiting for clients and aborts blocked I/O once ctx
+// is done. The partial result is then returned along with an error
+// wrapping the context error.
+func (s ThroughputServer) RunContext(ctx context.Context, l net.Listener) (*ThroughputResult, error) {
+	stop := watchListener(ctx, l)
+	defer stop()
+
+	conn, desc, err := acceptTest(ctx, l)
 	if err != nil {
-		return err
+		return throughputOutcome(ctx, nil, err)
 	}
 	defer conn.Close()
-	buf := make([]byte, s.msgSize)
-	for {
-		nread, err := conn.Read(buf)
+
+	result, err := s.runTest(ctx, l, conn, desc)
+	return throughputOutcome(ctx, result, err)
+}
+
+// runTest runs the throughput test described by desc over conn. If the
+// client runs parallel streams, the connections of the other streams are
+// accepted on l.
+func (s ThroughputServer) runTest(ctx context.Context, l net.Listener, conn net.Conn, desc TestDescriptor) (*ThroughputResult, error) {
+	c, err := s.configure(desc)
+	if err == nil {
+		err = c.check(conn)
+	}
+	if err != nil {
+		return nil, rejectTest(conn, err)
+	}
+	if err := replyTest(conn, nil); err != nil {
+		return nil, err
+	}
+
+	if c.streams() == 1 {
+		return c.run(ctx, conn, false)
+	}
+
+	group := &streamGroup{conns: []net.Conn{conn}}
+	for len(group.conns) < c.streams() {
+		conn, streamDesc, err := acceptTest(ctx, l)
 		if err != nil {
-			return err
+			if interrupted(ctx, err) != nil || errors.Is(err, net.ErrClosed) {
+				return nil, err
+			}
+			log.Printf("rejected connection: %v", err)
+			continue
 		}
+		defer conn.Close()
 
-		if nread == 0 {
-			break
+		if streamDesc.Cookie != desc.Cookie {
+			err = rejectTest(conn, errors.New("server busy"))
+		} else {
+			err = replyTest(conn, nil)
+		}
+		if err != nil {
+			log.Printf("rejected %s test from %v: %v", streamDesc.Type, conn.RemoteAddr(), err)
+			continue
 		}
+		group.conns = append(group.conns, conn)
 	}
 
-	return nil
+	return c.runStreams(ctx, group.next(), false, false)
+}
+
+// Serve runs the server in daemon mode. It keeps accepting clients on l
+// and runs a throughput session with each of them. The result of every
+// session is logged. Serve returns nil after l is closed and all running
+// sessions are done, so closing l on a signal shuts the server down
+// gracefully.
+//
+// Sessions run one after another by default, clients wait until it is
+// their turn. If the server is configured to serve clients concurrently,
+// their sessions run at the same time. A session with parallel streams
+// starts once all of its connections have been accepted.
+func (s ThroughputServer) Serve(l net.Listener) error {
+	return s.ServeContext(context.Background(), l)
+}
+
+// ServeContext is like Serve but also stops once ctx is done. Running
+// sessions are interrupted then and an error wrapping the context error
+// is returned.
+func (s ThroughputServer) ServeContext(ctx context.Context, l net.Listener) error {
+	sessions := &sessions{concurrent: s.concurrent}
+	groups := &streamGroups{}
+	return serveTests(ctx, l, func(conn net.Conn, desc TestDescriptor) {
+		s.handle(ctx, conn, desc, sessions, groups)
+	})
+}
+
+// handle runs the throughput session described by desc over conn in daemon
+// mode and logs its outcome. Connections of parallel streams are collected
+// in groups until the session has all of them.
+func (s ThroughputServer) handle(ctx context.Context, conn net.Conn, desc TestDescriptor, sessions *sessions, groups *streamGroups) {
+	if desc.Load > 0 {
+		if !sessions.loading(desc.Cookie) {
+			log.Printf("rejected throughput test from %v: %v", conn.RemoteAddr(), rejectTest(conn, errNoLoadedLatency))
+			return
+		}
+		// the load of a latency test runs in the session of the latency
+		// test
+		sessions = nil
+	}
+
+	c, err := s.configure(desc)
+	if err == nil {
+		err = c.check(conn)
+	}
+	if err != nil {
+		log.Printf("rejected throughput test from %v: %v", conn.RemoteAddr(), rejectTest(conn, err))
+		return
+	}
+
+	if c.streams() == 1 {
+		sessions.begin()
+		defer sessions.end()
+		if err := replyTest(conn, nil); err != nil {
+			logThroughputSession(conn.RemoteAddr(), nil, err)
+			return
+		}
+		result, err := c.run(ctx, conn, false)
+		_, err = throughputOutcome(ctx, result, err)
+		logThroughputSession(conn.RemoteAddr(), result, err)
+		return
+	}
+
+	// The client starts sending once all of its streams are accepted,
+	// so the connection completing the group is accepted last, when it
+	// is the session's turn.
+	group, last := groups.join(desc, conn)
+	if !last {
+		if err := replyTest(conn, nil); err == nil {
+			groups.wait(ctx, group)
+		}
+		return
+	}
+	defer close(group.done)
+
+	sessions.begin()
+	defer sessions.end()
+	if err := replyTest(conn, nil); err != nil {
+		logThroughputSession(conn.RemoteAddr(), nil, err)
+		return
+	}
+	result, err := c.runStreams(ctx, group.next(), false, false)
+	_, err = throughputOutcome(ctx, result, err)
+	logThroughputSession(conn.RemoteAddr(), result, err)
+}
+
+// logThroughputSession logs the outcome of a throughput session with peer.
+func logThroughputSession(peer net.Addr, result *ThroughputResult, err error) {
+	if err != nil {
+		log.Printf("throughput session with %v failed: %v", peer, err)
+		return
+	}
+	log.Printf("throughput session with %v: %d bytes in %v, %.2f MB/s", peer, result.Bytes, result.Elapsed, result.AvgThroughput)
 }
 
 // ThroughputClient holds parameters for the client side of throughput estimation.
 type ThroughputClient struct {
-	msgSize int
-	numMsg  int
-	timeout int
+	throughputConfig
+	cookie string // cookie of the latency test the run is the load of
+	load   bool   // whether the run loads the network during a latency test
 }
 
 // NewThroughputClient returns an instance of ThroughputClient. You can
 // call its Run method to start client for throughput estimation.
 func NewThroughputClient(msgSize, numMsg, timeout int) ThroughputClient {
 	return ThroughputClient{
-		msgSize: msgSize,
-		timeout: timeout,
-		numMsg:  numMsg,
+		throughputConfig: throughputConfig{
+			msgSize: msgSize,
+			timeout: timeout,
+			numMsg:  numMsg,
+		},
 	}
 }
 
+// OnInterval returns a copy of the client that calls fn with the results
+// of every reporting interval while Run is in progress. fn should return
+// quickly. In bidirectional mode intervals are reported for both directions,
+// fn is never called concurrently though. Intervals are only reported if
+// the client is configured with an interval.
+func (c ThroughputClient) OnInterval(fn func(ThroughputResult)) ThroughputClient {
+	c.onInterval = fn
+	return c
+}
+
 // Run sends the configured number of messages over the connection and
 // returns average throughput in MB/s along with other details.
+//
+// If the client is configured with a duration, it ignores the number of
+// messages and the timeout, and keeps sending until the duration has passed.
+//
+// In reverse direction the client reads the data sent by the server instead
+// and measures the throughput as the receiver. In bidirectional mode it
+// does both at the same time.
+//
+// If the client is configured with a bitrate, writes are paced with a
+// token bucket so that the data is sent at no more than the bitrate. In
+// reverse and bidirectional mode the server paces its writes the same.
+// Parallel streams share the bitrate evenly.
+//
+// Before any data is sent the client sends a test descriptor with its
+// configuration, so the server does not need to be configured the same.
+//
+// Over datagram sockets, e.g. UDP, the client sends sequence numbered and
+// timestamped datagrams at the configured bitrate or DefaultDatagramBitrate
+// and the server reports loss, reordering and jitter back. Only the
+// forward direction and a single stream are supported then.
 func (c ThroughputClient) Run(conn net.Conn) (*ThroughputResult, error) {
+	return c.RunContext(context.Background(), conn)
+}
+
+// RunContext is like Run but sets the deadline of ctx on the connection and
+// aborts blocked I/O once ctx is done. The partial result is then returned
+// along with an error wrapping the context error.
+func (c ThroughputClient) RunContext(ctx context.Context, conn net.Conn) (*ThroughputResult, error) {
+	c.parallel = 1
+	if err := c.check(conn); err != nil {
+		return nil, err
+	}
+	hs, err := handshakeTLS(ctx, conn)
+	if err != nil {
+		return throughputOutcome(ctx, nil, err)
+	}
+	if err := handshake(ctx, conn, c.descriptor()); err != nil {
+		return throughputOutcome(ctx, nil, err)
+	}
+	socket := socketSettings(conn)
+	result, err := c.run(ctx, conn, true)
+	if result != nil {
+		result.HandshakeTime, result.Socket = hs, socket
+		result.TCPInfo = tcpInfo(conn)
+	}
+	return throughputOutcome(ctx, result, err)
+}
+
+// RunStreams runs the client over all connections in parallel, one stream
+// per connection, and returns the aggregated result along with the results
+// of the individual streams. Each stream sends the configured number of
+// messages or runs for the configured duration. The streams start once the
+// server has accepted all of them. With a single connection RunStreams is
+// the same as Run.
+func (c ThroughputClient) RunStreams(conns []net.Conn) (*ThroughputResult, error) {
+	return c.RunStreamsContext(context.Background(), conns)
+}
+
+// RunStreamsContext is like RunStreams but aborts all streams once ctx is
+// done, see RunContext.
+func (c ThroughputClient) RunStreamsContext(ctx context.Context, conns []net.Conn) (*ThroughputResult, error) {
+	switch len(conns) {
+	case 0:
+		return nil, errors.New("no connections")
+	case 1:
+		return c.RunContext(ctx, conns[0])
+	}
+	c.parallel = len(conns)
+	if err := c.check(conns[0]); err != nil {
+		return nil, err
+	}
+
+	desc := c.descriptor()
+	if desc.Cookie == "" {
+		cookie, err := newCookie()
+		if err != nil {
+			return nil, err
+		}
+		desc.Cookie = cookie
+	}
+	handshakes := make([]time.Duration, len(conns))
+	for i, conn := range conns {
+		desc.Stream = i + 1
+		hs, err := handshakeTLS(ctx, conn)
+		if err == nil {
+			err = handshake(ctx, conn, desc)
+		}
+		if err != nil {
+			return throughputOutcome(ctx, nil, fmt.Errorf("stream %d: %w", i+1, err))
+		}
+		handshakes[i] = hs
+	}
+
+	next := 0
+	result, err := c.runStreams(ctx, func() (net.Conn, error) {
+		conn := conns[next]
+		next++
+		return conn, nil
+	}, true, false)
+	if result != nil {
+		for i := range result.Streams {
+			s := &result.Streams[i]
+			s.HandshakeTime = handshakes[s.Stream-1]
+			s.Socket = socketSettings(conns[s.Stream-1])
+			s.TCPInfo = tcpInfo(conns[s.Stream-1])
+			if s.HandshakeTime > result.HandshakeTime {
+				result.HandshakeTime = s.HandshakeTime
+			}
+		}
+		if len(result.Streams) > 0 {
+			result.Socket = result.Streams[0].Socket
+		}
+	}
+	return throughputOutcome(ctx, result, err)
+}
+
+// descriptor returns the test descriptor sent to the server.
+func (c ThroughputClient) descriptor() TestDescriptor {
+	desc := c.throughputConfig.descriptor()
+	if c.load {
+		desc.Cookie, desc.Load = c.cookie, c.streams()
+	}
+	return desc
+}
+
+// runSender sends data over the connection and waits for the receiver to
+// report back.
+func (c throughputConfig) runSender(d *deadlines) (*ThroughputResult, error) {
+	result, err := c.send(d)
+	result.Direction = c.direction
+	if err != nil {
+		return result, err
+	}
+
+	received, elapsed, err := c.awaitReport(d)
+	if err != nil {
+		return result, err
+	}
+	result.setReceiver(received, elapsed)
+
+	return result, nil
+}
+
+// runReceiver reads data from the connection until EOF and reports the
+// receiver side measurements back to the sender.
+func (c throughputConfig) runReceiver(d *deadlines) (*ThroughputResult, error) {
+	result, err := c.receive(d)
+	result.setReceiver(result.Bytes, result.Elapsed)
+	result.Direction = c.direction
+	if err != nil {
+		return result, err
+	}
+
+	// The sender may have closed the connection without waiting for the
+	// report, so failing to send it is not an error.
+	_ = writeReport(d.conn, result.Bytes, result.Elapsed)
+
+	return result, nil
+}
+
+// runBidir sends and receives over the connection at the same time. Both
+// sides close their write side when done sending and read until the peer
+// does.
+func (c throughputConfig) runBidir(d *deadlines, client bool) (*ThroughputResult, error) {
+	cw, ok := d.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return nil, errors.New("bidirectional runs need a connection that supports CloseWrite")
+	}
+
+	sendDir, recvDir := DirectionForward, DirectionReverse
+	if !client {
+		sendDir, recvDir = recvDir, sendDir
+	}
+
+	var mu sync.Mutex
+	report := func(direction string) func(ThroughputResult) {
+		if c.onInterval == nil {
+			return nil
+		}
+		return func(r ThroughputResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			r.Direction = direction
+			c.onInterval(r)
+		}
+	}
+	sc, rc := c, c
+	sc.onInterval, rc.onInterval = report(sendDir), report(recvDir)
+
+	// the direction failing first aborts the other one and its error is
+	// the one returned
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() { firstErr = err })
+	}
+
+	var sent *ThroughputResult
+	sendDone := make(chan struct{})
+	go func() {
+		defer close(sendDone)
+		var err error
+		sent, err = sc.send(d)
+		if err == nil {
+			err = cw.CloseWrite()
+		}
+		if err != nil {
+			fail(err)
+			d.setRead(aLongTimeAgo)
+		}
+	}()
+
+	received, err := rc.receive(d)
+	if err != nil {
+		fail(err)
+		d.setWrite(aLongTimeAgo)
+	}
+	<-sendDone
+
+	received.setReceiver(received.Bytes, received.Elapsed)
+	sent.Direction, received.Direction = sendDir, recvDir
+
+	elapsed := sent.Elapsed
+	if received.Elapsed > elapsed {
+		elapsed = received.Elapsed
+	}
+	result := newThroughputResult(c.msgSize, sent.Bytes+received.Bytes, elapsed)
+	result.Direction = DirectionBidir
+	if client {
+		result.Upstream, result.Downstream = sent, received
+	} else {
+		result.Upstream, result.Downstream = received, sent
+	}
+
+	return result, firstErr
+}
+
+// send writes messages to the connection until the configured number of
+// messages is sent or the timeout is reached. If a duration is configured,
+// it keeps sending until the duration has passed instead. Writes are paced
+// if a bitrate is configured. The result is never nil, if sending fails it
+// holds what was sent until then.
+func (c throughputConfig) send(d *deadlines) (*ThroughputResult, error) {
 	buf := make([]byte, c.msgSize)
 	t1 := time.Now()
 	stopTime := t1.Add(time.Duration(c.timeout) * time.Millisecond)
-	msgSent := 0
+	if c.duration > 0 {
+		stopTime = t1.Add(time.Duration(c.duration) * time.Millisecond)
+		// Unblock the write in progress when the time is up. Not every
+		// connection supports deadlines, e.g. konnectivity tunnels don't,
+		// in which case the last write may overrun the duration. TLS
+		// connections are left alone too, a timed out write breaks them
+		// for good and the end of the stream could not be sent anymore.
+		if !isTLS(d.conn) {
+			d.setWrite(stopTime)
+			defer d.setWrite(time.Time{})
+		}
+	}
+	meter := newThroughputMeter(c.msgSize, c.interval, c.onInterval, t1)
+	p := newPacer(c.bitrate, t1)
+	if c.duration > 0 {
+		p.until = stopTime
+	}
 
-	for n := 0; n < c.numMsg; n++ {
-		nwrite, err := conn.Write(buf)
+	for n := 0; c.duration > 0 || n < c.numMsg; n++ {
+		if err := p.wait(d.ctx, len(buf)); err != nil {
+			return meter.result(time.Now()), err
+		}
+		if c.duration > 0 && !time.Now().Before(stopTime) {
+			break
+		}
+
+		nwrite, err := d.conn.Write(buf)
+		now := time.Now()
+		meter.add(nwrite, now)
 		if err != nil {
-			return nil, err
+			if c.duration > 0 && errors.Is(err, os.ErrDeadlineExceeded) && !now.Before(stopTime) && d.ctx.Err() == nil {
+				break
+			}
+			return meter.result(now), err
 		}
 		if nwrite != c.msgSize {
-			return nil, fmt.Errorf("bad nwrite = %d", nwrite)
+			return meter.result(now), fmt.Errorf("bad nwrite = %d", nwrite)
 		}
 
-		msgSent = n + 1
-		if time.Now().After(stopTime) {
+		if now.After(stopTime) {
 			break
 		}
 	}
 
-	elapsed := time.Since(t1)
+	return meter.result(time.Now()), nil
+}
 
-	return &ThroughputResult{
-		MsgSize:       c.msgSize,
-		NumMsg:        msgSent,
-		Elapsed:       elapsed,
-		AvgThroughput: float64(msgSent*c.msgSize*1000) / float64(elapsed.Nanoseconds()),
-	}, nil
+// receive reads from the connection until EOF. The elapsed time of the
+// result is measured from the first byte received. The result is never
+// nil, if reading fails it holds what was received until then.
+func (c throughputConfig) receive(d *deadlines) (*ThroughputResult, error) {
+	buf := make([]byte, c.msgSize)
+	var meter *throughputMeter
+	result := func(now time.Time) *ThroughputResult {
+		if meter == nil {
+			return newThroughputResult(c.msgSize, 0, 0)
+		}
+		return meter.result(now)
+	}
+
+	for {
+		nread, err := d.conn.Read(buf)
+		now := time.Now()
+		if nread > 0 {
+			if meter == nil {
+				meter = newThroughputMeter(c.msgSize, c.interval, c.onInterval, now)
+			}
+			meter.add(nread, now)
+		}
+		if err == io.EOF {
+			return result(now), nil
+		}
+		if err != nil {
+			return result(now), err
+		}
+	}
+}
+
+// awaitReport closes the write side of the connection to signal the end of
+// the stream and waits for the receiver to report back. It returns zero
+// values if the connection cannot be half-closed or the receiver does not
+// report.
+func (c throughputConfig) awaitReport(d *deadlines) (int64, time.Duration, error) {
+	cw, ok := d.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return 0, 0, nil
+	}
+	if err := cw.CloseWrite(); err != nil {
+		return 0, 0, err
+	}
+
+	d.setRead(time.Now().Add(c.reportTimeout()))
+	defer d.setRead(time.Time{})
+
+	received, elapsed, err := readReport(d.conn)
+	if err == io.EOF {
+		return 0, 0, nil
+	}
+	return received, elapsed, err
+}
+
+// reportTimeout returns how long the sender waits for the report of the
+// receiver. Duration runs ignore the timeout, they and runs without one
+// wait up to handshakeTimeout.
+func (c throughputConfig) reportTimeout() time.Duration {
+	if c.duration > 0 || c.timeout <= 0 {
+		return handshakeTimeout
+	}
+	return time.Duration(c.timeout) * time.Millisecond
+}
+
+// setReceiver sets the receiver side measurements of the result.
+func (r *ThroughputResult) setReceiver(received int64, elapsed time.Duration) {
+	r.ReceivedBytes = received
+	r.ReceiverElapsed = elapsed
+	if elapsed > 0 {
+		r.ReceiverThroughput = float64(received*1000) / float64(elapsed.Nanoseconds())
+	}
+}
+
+// reportSize is the size of the report sent by the receiver of a throughput
+// run: the number of bytes received and the elapsed time in nanoseconds.
+const reportSize = 16
+
+// writeReport sends the receiver side measurements to the sender.
+func writeReport(w io.Writer, received int64, elapsed time.Duration) error {
+	buf := make([]byte, reportSize)
+	binary.BigEndian.PutUint64(buf[0:], uint64(received))
+	binary.BigEndian.PutUint64(buf[8:], uint64(elapsed))
+	_, err := w.Write(buf)
+	return err
+}
+
+// readReport reads the receiver side measurements sent by writeReport.
+func readReport(r io.Reader) (int64, time.Duration, error) {
+	buf := make([]byte, reportSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, 0, err
+	}
+	return int64(binary.BigEndian.Uint64(buf[0:])), time.Duration(binary.BigEndian.Uint64(buf[8:])), nil
 }
@@ -1,6 +1,7 @@
 package benchmate
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
@@ -42,7 +43,376 @@ func TestThroughput(t *testing.T) {
 
 	fmt.Println("average throughput:", float64(result.NumMsg*result.MsgSize)*1000/float64(result.Elapsed.Nanoseconds()), "MB/s")
 
+	if result.ReceivedBytes != result.Bytes {
+		t.Errorf("expected server to receive %d bytes, got %d", result.Bytes, result.ReceivedBytes)
+	}
+
+	if result.ReceiverThroughput == 0 {
+		t.Error("receiver throughput is 0")
+	}
+
 	t.Log(result)
 
 	time.Sleep(time.Millisecond * 500)
 }
+
+func TestThroughputDuration(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultThroughputOptions()
+	o.MsgSize = 64000
+	o.NumMsg = 1
+	o.Duration = 500
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		_ = o.ThroughputServer().Run(l)
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := o.ThroughputClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+
+	if result.Elapsed < 500*time.Millisecond || result.Elapsed > 2*time.Second {
+		t.Errorf("expected run to last about 500ms, got %v", result.Elapsed)
+	}
+
+	if result.NumMsg <= 1 || result.Bytes < int64(result.NumMsg*result.MsgSize) {
+		t.Errorf("expected more than the configured number of messages, got %d messages, %d bytes", result.NumMsg, result.Bytes)
+	}
+
+	t.Log(result)
+}
+
+func TestThroughputDurationNoTimeout(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	// duration runs ignore the timeout, waiting for the report included
+	o := DefaultThroughputOptions()
+	o.Duration = 200
+	o.Timeout = 0
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		_, _ = NewServer().Run(l)
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := o.ThroughputClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+	if result.ReceivedBytes != result.Bytes {
+		t.Errorf("expected receiver to report %d bytes, got %d", result.Bytes, result.ReceivedBytes)
+	}
+}
+
+func TestThroughputReverse(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultThroughputOptions()
+	o.MsgSize = 64000
+	o.NumMsg = 1000
+	o.Direction = DirectionReverse
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	serverResult := make(chan *ThroughputResult, 1)
+	go func() {
+		result, err := o.ThroughputServer().RunContext(context.Background(), l)
+		if err != nil {
+			t.Error(err)
+		}
+		serverResult <- result
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := o.ThroughputClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+
+	expected := int64(o.NumMsg * o.MsgSize)
+	if result.Bytes != expected || result.ReceivedBytes != expected {
+		t.Errorf("expected client to receive %d bytes, got %d", expected, result.Bytes)
+	}
+
+	sent := <-serverResult
+	if sent == nil {
+		t.FailNow()
+	}
+	if sent.Bytes != expected || sent.ReceivedBytes != expected {
+		t.Errorf("expected server to send %d bytes and client to report them, got %d sent, %d reported", expected, sent.Bytes, sent.ReceivedBytes)
+	}
+
+	t.Log(result)
+}
+
+func TestThroughputBitrate(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultThroughputOptions()
+	o.MsgSize = 16000
+	o.Duration = 500
+	o.Bitrate = 80 * 1000 * 1000
+	o.Direction = DirectionReverse
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		_ = NewThroughputServer(1024).Run(l)
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := o.ThroughputClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+
+	// the server paces its writes at the bitrate of the client, 10 MB/s
+	if result.AvgThroughput < 8 || result.AvgThroughput > 10.5 {
+		t.Errorf("expected throughput of about 10 MB/s, got %.2f MB/s", result.AvgThroughput)
+	}
+
+	t.Log(result)
+}
+
+func TestThroughputBitrateLow(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	// less than a bit per second per stream, a write every 64s at most
+	o := DefaultThroughputOptions()
+	o.MsgSize = 64000
+	o.Duration = 300
+	o.Bitrate = 2
+	o.Parallel = 4
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		_, _ = NewServer().Run(l)
+	}()
+
+	var conns []net.Conn
+	for i := 0; i < o.Parallel; i++ {
+		conn, err := net.Dial(o.Network, o.Addr)
+		if err != nil {
+			t.Fatalf("Error making connection: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	start := time.Now()
+	result, err := o.ThroughputClient().RunStreams(conns)
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected run to end after about 300ms, took %v", elapsed)
+	}
+	if max := int64(o.Parallel * o.MsgSize); result.Bytes > max {
+		t.Errorf("expected streams to be paced to a message each, got %d bytes", result.Bytes)
+	}
+}
+
+func TestThroughputBidir(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultThroughputOptions()
+	o.MsgSize = 64000
+	o.NumMsg = 1000
+	o.Direction = DirectionBidir
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		err := o.ThroughputServer().Run(l)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := o.ThroughputClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+
+	expected := int64(o.NumMsg * o.MsgSize)
+	if result.Upstream == nil || result.Upstream.Bytes != expected {
+		t.Errorf("expected %d bytes upstream, got %+v", expected, result.Upstream)
+	}
+	if result.Downstream == nil || result.Downstream.Bytes != expected || result.Downstream.ReceivedBytes != expected {
+		t.Errorf("expected %d bytes downstream, got %+v", expected, result.Downstream)
+	}
+	if result.Bytes != 2*expected {
+		t.Errorf("expected %d bytes in total, got %d", 2*expected, result.Bytes)
+	}
+
+	t.Log(prettyJSON(result))
+}
+
+func TestThroughputParallel(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultThroughputOptions()
+	o.MsgSize = 64000
+	o.NumMsg = 100
+	o.Parallel = 4
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	serverResult := make(chan *ThroughputResult, 1)
+	go func() {
+		result, err := o.ThroughputServer().RunContext(context.Background(), l)
+		if err != nil {
+			t.Error(err)
+		}
+		serverResult <- result
+	}()
+
+	var conns []net.Conn
+	for i := 0; i < o.Parallel; i++ {
+		conn, err := net.Dial(o.Network, o.Addr)
+		if err != nil {
+			t.Fatalf("Error making connection: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	result, err := o.ThroughputClient().RunStreams(conns)
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+
+	if len(result.Streams) != o.Parallel {
+		t.Fatalf("expected %d streams, got %d", o.Parallel, len(result.Streams))
+	}
+	for i, r := range result.Streams {
+		if r.Stream != i+1 || r.NumMsg != o.NumMsg {
+			t.Errorf("stream %d: expected %d messages, got %d", i+1, o.NumMsg, r.NumMsg)
+		}
+	}
+
+	expected := int64(o.Parallel * o.NumMsg * o.MsgSize)
+	if result.Bytes != expected || result.ReceivedBytes != expected {
+		t.Errorf("expected %d bytes sent and received, got %d sent, %d received", expected, result.Bytes, result.ReceivedBytes)
+	}
+
+	received := <-serverResult
+	if received == nil || received.Bytes != expected || len(received.Streams) != o.Parallel {
+		t.Errorf("expected server to receive %d bytes on %d streams, got %+v", expected, o.Parallel, received)
+	}
+}
+
+func TestThroughputServe(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultThroughputOptions()
+	o.MsgSize = 64000
+	o.NumMsg = 100
+	o.Parallel = 2
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- o.ThroughputServer().Serve(l)
+	}()
+
+	for i := 0; i < 3; i++ {
+		var conns []net.Conn
+		for j := 0; j < o.Parallel; j++ {
+			conn, err := net.Dial(o.Network, o.Addr)
+			if err != nil {
+				t.Fatalf("Error making connection: %v", err)
+			}
+			defer conn.Close()
+			conns = append(conns, conn)
+		}
+
+		result, err := o.ThroughputClient().RunStreams(conns)
+		if err != nil {
+			t.Fatalf("Error running throughput test %d: %v", i, err)
+		}
+		if expected := int64(o.Parallel * o.NumMsg * o.MsgSize); result.ReceivedBytes != expected {
+			t.Errorf("expected server to receive %d bytes, got %d", expected, result.ReceivedBytes)
+		}
+	}
+
+	l.Close()
+	if err := <-done; err != nil {
+		t.Errorf("expected server to shut down cleanly, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// TLSOptions configure TLS for tests, so the cost of encryption can be
+// compared with plain connections over the same path. Setting CA on both
+// sides runs the tests over mutual TLS.
+type TLSOptions struct {
+	Cert         string   `json:"cert"`                   // PEM certificate file, required by servers, clients present it to servers requiring client certificates
+	Key          string   `json:"key"`                    // PEM private key file of Cert
+	CA           string   `json:"ca"`                     // PEM file of the CAs verifying the peer, servers require client certificates if set, clients use the system roots if empty
+	ServerName   string   `json:"serverName"`             // name the server certificate is verified against, the host of Addr by default
+	MinVersion   string   `json:"minVersion"`             // minimum TLS version (1.0, 1.1, 1.2 or 1.3), 1.2 by default
+	CipherSuites []string `json:"cipherSuites,omitempty"` // names of the cipher suites enabled up to TLS 1.2, the defaults of Go if empty
+}
+
+// tlsVersions maps the versions of TLSOptions.MinVersion.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ServerConfig returns the TLS configuration of servers.
+func (t TLSOptions) ServerConfig() (*tls.Config, error) {
+	if t.Cert == "" || t.Key == "" {
+		return nil, errors.New("tls servers need a certificate and a key")
+	}
+	config, err := t.config()
+	if err != nil {
+		return nil, err
+	}
+	if config.ClientCAs, err = t.certPool(); err != nil {
+		return nil, err
+	}
+	if config.ClientCAs != nil {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientConfig returns the TLS configuration of clients.
+func (t TLSOptions) ClientConfig() (*tls.Config, error) {
+	if (t.Cert == "") != (t.Key == "") {
+		return nil, errors.New("tls client certificates need both a certificate and a key")
+	}
+	config, err := t.config()
+	if err != nil {
+		return nil, err
+	}
+	if config.RootCAs, err = t.certPool(); err != nil {
+		return nil, err
+	}
+	config.ServerName = t.ServerName
+	return config, nil
+}
+
+// config returns the settings shared by servers and clients.
+func (t TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.MinVersion != "" {
+		v, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls version %q", t.MinVersion)
+		}
+		config.MinVersion = v
+	}
+
+	for _, name := range t.CipherSuites {
+		id, ok := cipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// certPool returns the CAs of the CA file, or nil if there is none.
+func (t TLSOptions) certPool() (*x509.CertPool, error) {
+	if t.CA == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(t.CA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", t.CA)
+	}
+	return pool, nil
+}
+
+// cipherSuite returns the ID of the cipher suite called name.
+func cipherSuite(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, s := range suites {
+			if s.Name == name {
+				return s.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// WrapListener returns a listener serving TLS over the connections of l if
+// the options configure TLS, and l otherwise.
+func (o Options) WrapListener(l net.Listener) (net.Listener, error) {
+	if o.TLS == nil {
+		return l, nil
+	}
+	if _, ok := l.(*packetListener); ok {
+		return nil, errors.New("tls is not supported over datagram sockets")
+	}
+	config, err := o.TLS.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	return tlsListener{Listener: l, config: config}, nil
+}
+
+// WrapConn returns a TLS client connection over conn if the options
+// configure TLS, and conn otherwise. The handshake is left to the test,
+// which reports how long it took. Without a server name the certificate of
+// the server is verified against the host of Addr.
+func (o Options) WrapConn(conn net.Conn) (net.Conn, error) {
+	if o.TLS == nil {
+		return conn, nil
+	}
+	if isDatagram(conn) {
+		return nil, errors.New("tls is not supported over datagram sockets")
+	}
+	config, err := o.TLS.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.ServerName == "" {
+		config.ServerName = "localhost"
+		if host, _, err := net.SplitHostPort(o.Addr); err == nil && host != "" {
+			config.ServerName = host
+		}
+	}
+	return tlsConn{Conn: tls.Client(conn, config), raw: conn}, nil
+}
+
+// tlsListener is like the listener of tls.NewListener but keeps supporting
+// the deadlines of the listener it wraps, so accepting can be aborted
+// without closing it.
+type tlsListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+// Accept accepts the next connection and returns it as TLS server
+// connection. The handshake is done on the first read or write.
+func (l tlsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return tlsConn{Conn: tls.Server(conn, l.config), raw: conn}, nil
+}
+
+// SetDeadline sets the deadline of the wrapped listener if it supports
+// deadlines.
+func (l tlsListener) SetDeadline(t time.Time) error {
+	dl, ok := l.Listener.(interface{ SetDeadline(time.Time) error })
+	if !ok {
+		return errors.New("listener does not support deadlines")
+	}
+	return dl.SetDeadline(t)
+}
+
+// isTLS reports whether conn is a TLS connection.
+func isTLS(conn net.Conn) bool {
+	_, ok := conn.(interface{ ConnectionState() tls.ConnectionState })
+	return ok
+}
+
+// handshakeTLS completes the TLS handshake of conn unless conn is no TLS
+// connection or completed it already, and returns how long it took. Tests
+// call it before they start measuring, since otherwise the first write of
+// a test would do the handshake.
+func handshakeTLS(ctx context.Context, conn net.Conn) (time.Duration, error) {
+	tc, ok := conn.(interface {
+		HandshakeContext(context.Context) error
+		ConnectionState() tls.ConnectionState
+	})
+	if !ok || tc.ConnectionState().HandshakeComplete {
+		return 0, nil
+	}
+	start := time.Now()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return 0, fmt.Errorf("tls handshake: %w", err)
+	}
+	return time.Since(start), nil
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCerts writes a CA and certificates for localhost signed by it to
+// dir and returns the TLS options of a server and a client using them.
+func writeCerts(t *testing.T, dir string) (server, client TLSOptions) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "benchmate test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", caDER)
+
+	for i, name := range []string{"server", "client"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{"localhost"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+		writePEM(t, filepath.Join(dir, name+"-key.pem"), "EC PRIVATE KEY", keyDER)
+	}
+
+	server = TLSOptions{Cert: filepath.Join(dir, "server.pem"), Key: filepath.Join(dir, "server-key.pem"), CA: filepath.Join(dir, "ca.pem")}
+	client = TLSOptions{Cert: filepath.Join(dir, "client.pem"), Key: filepath.Join(dir, "client-key.pem"), CA: filepath.Join(dir, "ca.pem")}
+	return server, client
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	mrand.Seed(time.Now().UnixNano())
+
+	dir, err := ioutil.TempDir("", "benchmate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverTLS, clientTLS := writeCerts(t, dir)
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf("localhost:%d", randPort())
+	o.NumMsg = 100
+	so, co := o, o
+	so.TLS, co.TLS = &serverTLS, &clientTLS
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	tl, err := so.WrapListener(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	go func() {
+		_ = so.Server().Serve(tl)
+	}()
+
+	dial := func(o Options) (net.Conn, error) {
+		conn, err := net.Dial(o.Network, o.Addr)
+		if err != nil {
+			return nil, err
+		}
+		return o.WrapConn(conn)
+	}
+
+	conn, err := dial(co)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	lat, err := co.LatencyClient().Run(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+	if lat.NumMsg != 2*o.NumMsg || lat.HandshakeTime <= 0 {
+		t.Errorf("expected %d pings and the handshake time, got %d pings and %v", o.NumMsg, lat.NumMsg/2, lat.HandshakeTime)
+	}
+
+	tpo := co
+	tpo.MsgSize = 64 * 1024
+	tpo.NumMsg = 100
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := dial(tpo)
+		if err != nil {
+			t.Fatalf("Error making connection: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	tp, err := tpo.ThroughputClient().RunStreams(conns)
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+	if tp.Bytes != int64(2*tpo.NumMsg*tpo.MsgSize) || tp.HandshakeTime <= 0 || tp.Streams[1].HandshakeTime <= 0 {
+		t.Errorf("expected %d bytes and the handshake times, got %+v", 2*tpo.NumMsg*tpo.MsgSize, tp)
+	}
+
+	// duration runs end the stream without cutting the last write
+	do := tpo
+	do.Duration = 300
+	conn, err = dial(do)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	tp, err = do.ThroughputClient().Run(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Error running throughput test for a duration: %v", err)
+	}
+	if tp.Bytes == 0 || tp.ReceivedBytes != tp.Bytes {
+		t.Errorf("expected the server to receive all %d bytes, got %d", tp.Bytes, tp.ReceivedBytes)
+	}
+
+	lo := co
+	lo.NumMsg = 10
+	to := tpo
+	to.Duration = 300
+	conns = nil
+	for i := 0; i < 2; i++ {
+		conn, err := dial(co)
+		if err != nil {
+			t.Fatalf("Error making connection: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	loaded, err := NewLoadedLatencyClient(lo.LatencyClient(), to.ThroughputClient()).Run(conns[0], conns[1:])
+	if err != nil {
+		t.Fatalf("Error running latency test under load: %v", err)
+	}
+	if loaded.HandshakeTime <= 0 || loaded.Throughput.HandshakeTime <= 0 {
+		t.Errorf("expected the handshake times of the latency test and its load, got %v and %v", loaded.HandshakeTime, loaded.Throughput.HandshakeTime)
+	}
+
+	// the server requires a client certificate
+	anonymous := co
+	anonymous.TLS = &TLSOptions{CA: clientTLS.CA}
+	conn, err = dial(anonymous)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := anonymous.LatencyClient().Run(conn); err == nil {
+		t.Error("expected client without certificate to be rejected")
+	}
+}
+
+// noDeadlineListener hides the deadlines of its listener, like listeners of
+// transports without them.
+type noDeadlineListener struct {
+	net.Listener
+}
+
+func TestTLSListenerContext(t *testing.T) {
+	mrand.Seed(time.Now().UnixNano())
+
+	dir, err := ioutil.TempDir("", "benchmate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverTLS, _ := writeCerts(t, dir)
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf("localhost:%d", randPort())
+	o.TLS = &serverTLS
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	tl, err := o.WrapListener(noDeadlineListener{l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- o.Server().ServeContext(ctx, tl)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected server to stop once the context is done")
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	for _, o := range []TLSOptions{
+		{MinVersion: "1.4"},
+		{CipherSuites: []string{"TLS_NULL"}},
+		{Cert: "cert.pem"},
+		{CA: "does-not-exist.pem"},
+	} {
+		if _, err := o.ClientConfig(); err == nil {
+			t.Errorf("expected %+v to be rejected", o)
+		}
+	}
+
+	if _, err := (TLSOptions{}).ServerConfig(); err == nil {
+		t.Error("expected server without certificate to be rejected")
+	}
+
+	c, err := TLSOptions{MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MinVersion != tls.VersionTLS13 || len(c.CipherSuites) != 1 {
+		t.Errorf("expected TLS 1.3 and a cipher suite, got %x and %v", c.MinVersion, c.CipherSuites)
+	}
+}
@@ -0,0 +1,376 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// TransactionResult contains the details of a transaction rate run, like
+// TCP_RR and TCP_CRR of netperf. A transaction is a request followed by
+// its response. In runs with a new connection per transaction it includes
+// connecting, the test handshake and closing the connection too. The
+// embedded LatencyStats describe the distribution of the transaction
+// times.
+type TransactionResult struct {
+	Transactions  int             `json:"transactions"`            // number of transactions done
+	Elapsed       time.Duration   `json:"elapsed"`                 // time elapsed in nanoseconds
+	Rate          float64         `json:"rate"`                    // transactions per second
+	ReqSize       int             `json:"reqSize"`                 // size of a request in bytes
+	RespSize      int             `json:"respSize"`                // size of a response in bytes
+	Connect       bool            `json:"connect,omitempty"`       // whether every transaction used a new connection
+	HandshakeTime time.Duration   `json:"handshakeTime,omitempty"` // time the TLS handshake took, the mean of all connections in runs with a new connection per transaction
+	Socket        *SocketSettings `json:"socket,omitempty"`        // options in effect on the socket of the client in runs over a single connection
+	LatencyStats
+	Samples []time.Duration `json:"-"` // time of every transaction in the order they were done
+
+	Start     time.Duration       `json:"start,omitempty"`     // offset of an interval from the start of the run
+	Intervals []TransactionResult `json:"intervals,omitempty"` // per interval results if interval reporting is enabled
+}
+
+// transactionServer answers the requests of transaction tests. It is
+// configured from the test descriptor only.
+type transactionServer struct {
+	reqSize  int
+	respSize int
+}
+
+// configure returns the server configured for the test described by desc.
+func (s transactionServer) configure(desc TestDescriptor) (transactionServer, error) {
+	if err := checkTest(desc, TestTypeTransaction); err != nil {
+		return s, err
+	}
+	if desc.RespSize <= 0 || desc.RespSize > maxMsgSize {
+		return s, fmt.Errorf("bad response size %d, expected 1 to %d bytes", desc.RespSize, maxMsgSize)
+	}
+
+	s.reqSize, s.respSize = desc.MsgSize, desc.RespSize
+	return s, nil
+}
+
+// runTest runs the transaction test described by desc over conn.
+func (s transactionServer) runTest(ctx context.Context, conn net.Conn, desc TestDescriptor) error {
+	s, err := s.configure(desc)
+	if err == nil {
+		err = checkTransactionConn(conn)
+	}
+	if err != nil {
+		return rejectTest(conn, err)
+	}
+	if err := replyTest(conn, nil); err != nil {
+		return err
+	}
+
+	_, err = s.answer(ctx, conn)
+	return err
+}
+
+// handle runs the transaction session described by desc over conn in
+// daemon mode and logs its outcome. Runs with a new connection per
+// transaction are only logged if they fail, a line per connection would
+// flood the log.
+func (s transactionServer) handle(ctx context.Context, conn net.Conn, desc TestDescriptor, sessions *sessions) {
+	s, err := s.configure(desc)
+	if err == nil {
+		err = checkTransactionConn(conn)
+	}
+	if err != nil {
+		log.Printf("rejected transaction test from %v: %v", conn.RemoteAddr(), rejectTest(conn, err))
+		return
+	}
+
+	sessions.begin()
+	defer sessions.end()
+	if err := replyTest(conn, nil); err != nil {
+		log.Printf("transaction session with %v failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	start := time.Now()
+	n, err := s.answer(ctx, conn)
+	if err != nil {
+		err = latencyServerOutcome(ctx, err)
+		log.Printf("transaction session with %v failed after %d transactions: %v", conn.RemoteAddr(), n, err)
+		return
+	}
+	if desc.NumMsg != 1 {
+		log.Printf("transaction session with %v: %d transactions in %v", conn.RemoteAddr(), n, time.Since(start))
+	}
+}
+
+// answer sends a response to every request of the client until the client
+// closes the connection. It returns the number of requests answered.
+func (s transactionServer) answer(ctx context.Context, conn net.Conn) (int, error) {
+	d := watch(ctx, conn)
+	defer d.stop()
+
+	req, resp := make([]byte, s.reqSize), make([]byte, s.respSize)
+	for n := 0; ; n++ {
+		_, err := io.ReadFull(conn, req)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if _, err := conn.Write(resp); err != nil {
+			return n, err
+		}
+	}
+}
+
+// checkTransactionConn returns an error if transaction tests cannot run
+// over conn.
+func checkTransactionConn(conn net.Conn) error {
+	if isDatagram(conn) {
+		return errors.New("transaction tests need a stream connection")
+	}
+	return nil
+}
+
+// TransactionClient holds parameters for the client side of transaction
+// rate runs.
+type TransactionClient struct {
+	reqSize    int
+	respSize   int
+	numMsg     int
+	timeout    int
+	duration   int
+	interval   int
+	onInterval func(TransactionResult)
+}
+
+// NewTransactionClient returns an instance of TransactionClient that runs
+// numMsg transactions with requests of reqSize and responses of respSize
+// bytes, unless the timeout in milliseconds is reached before.
+func NewTransactionClient(reqSize, respSize, numMsg, timeout int) TransactionClient {
+	return TransactionClient{
+		reqSize:  reqSize,
+		respSize: respSize,
+		numMsg:   numMsg,
+		timeout:  timeout,
+	}
+}
+
+// OnInterval returns a copy of the client that calls fn with the results
+// of every reporting interval while Run is in progress. fn is called from
+// the goroutine calling Run and should return quickly. Intervals are only
+// reported if the client is configured with an interval.
+func (c TransactionClient) OnInterval(fn func(TransactionResult)) TransactionClient {
+	c.onInterval = fn
+	return c
+}
+
+// Run runs transactions over the connection one after another, like
+// TCP_RR of netperf: it sends a request and waits for the whole response
+// before it sends the next one. Unlike the echo of latency runs, requests
+// and responses may differ in size. If the client is configured with a
+// duration, it runs transactions until the duration has passed instead of
+// the configured number of them.
+//
+// The server has to be a Server, which configures itself from the test
+// descriptor sent before the first request.
+func (c TransactionClient) Run(conn net.Conn) (*TransactionResult, error) {
+	return c.RunContext(context.Background(), conn)
+}
+
+// RunContext is like Run but aborts the run once ctx is done. The result of
+// the transactions done until then is returned along with an error wrapping
+// the context error.
+func (c TransactionClient) RunContext(ctx context.Context, conn net.Conn) (*TransactionResult, error) {
+	if err := checkTransactionConn(conn); err != nil {
+		return nil, err
+	}
+	hs, err := handshakeTLS(ctx, conn)
+	if err == nil {
+		err = handshake(ctx, conn, c.descriptor())
+	}
+	if err != nil {
+		return c.outcome(ctx, nil, false, err)
+	}
+
+	d := watch(ctx, conn)
+	defer d.stop()
+
+	socket := socketSettings(conn)
+	start := time.Now()
+	meter := c.newMeter(start, false)
+	req, resp := make([]byte, c.reqSize), make([]byte, c.respSize)
+	stopTime := c.stopTime(start)
+	for n := 0; c.duration > 0 || n < c.numMsg; n++ {
+		sent := time.Now()
+		if _, err := conn.Write(req); err != nil {
+			return c.outcome(ctx, meter, false, err)
+		}
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return c.outcome(ctx, meter, false, err)
+		}
+
+		now := time.Now()
+		meter.add(now.Sub(sent), now)
+		if now.After(stopTime) {
+			break
+		}
+	}
+
+	result := c.result(meter.result(time.Now()), false)
+	result.HandshakeTime, result.Socket = hs, socket
+	return result, nil
+}
+
+// RunConnect runs every transaction over a new connection returned by
+// dial, like TCP_CRR of netperf, which models short RPCs over fresh
+// connections. The request is sent along with the test descriptor, so a
+// transaction takes a single round trip after connecting. The server has
+// to serve the connections one after another, e.g. a Server in daemon
+// mode.
+func (c TransactionClient) RunConnect(dial DialFunc) (*TransactionResult, error) {
+	return c.RunConnectContext(context.Background(), dial)
+}
+
+// RunConnectContext is like RunConnect but aborts the run once ctx is done,
+// see RunContext.
+func (c TransactionClient) RunConnectContext(ctx context.Context, dial DialFunc) (*TransactionResult, error) {
+	desc := c.descriptor()
+	desc.NumMsg = 1
+
+	start := time.Now()
+	meter := c.newMeter(start, true)
+	req, resp := make([]byte, c.reqSize), make([]byte, c.respSize)
+	stopTime := c.stopTime(start)
+	var handshakes time.Duration
+	for n := 0; c.duration > 0 || n < c.numMsg; n++ {
+		sent := time.Now()
+		hs, err := c.transact(ctx, dial, desc, req, resp)
+		if err != nil {
+			return c.outcome(ctx, meter, true, fmt.Errorf("transaction %d: %w", n+1, err))
+		}
+		handshakes += hs
+
+		now := time.Now()
+		meter.add(now.Sub(sent), now)
+		if now.After(stopTime) {
+			break
+		}
+	}
+
+	result := c.result(meter.result(time.Now()), true)
+	if result.Transactions > 0 {
+		result.HandshakeTime = handshakes / time.Duration(result.Transactions)
+	}
+	return result, nil
+}
+
+// transact runs a single transaction over a new connection. It returns how
+// long the TLS handshake took if the connection uses TLS.
+func (c TransactionClient) transact(ctx context.Context, dial DialFunc, desc TestDescriptor, req, resp []byte) (time.Duration, error) {
+	conn, err := dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := checkTransactionConn(conn); err != nil {
+		return 0, err
+	}
+
+	hs, err := handshakeTLS(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if err := handshakeData(ctx, conn, desc, req); err != nil {
+		return 0, err
+	}
+	d := watch(ctx, conn)
+	defer d.stop()
+	_, err = io.ReadFull(conn, resp)
+	return hs, err
+}
+
+// descriptor returns the test descriptor sent to the server.
+func (c TransactionClient) descriptor() TestDescriptor {
+	return TestDescriptor{
+		Type:     TestTypeTransaction,
+		MsgSize:  c.reqSize,
+		RespSize: c.respSize,
+		NumMsg:   c.numMsg,
+		Timeout:  c.timeout,
+		Duration: c.duration,
+	}
+}
+
+// stopTime returns the time a run starting at start ends at the latest.
+func (c TransactionClient) stopTime(start time.Time) time.Time {
+	if c.duration > 0 {
+		return start.Add(time.Duration(c.duration) * time.Millisecond)
+	}
+	return start.Add(time.Duration(c.timeout) * time.Millisecond)
+}
+
+// newMeter returns a meter for the transaction times of a run starting at
+// start.
+func (c TransactionClient) newMeter(start time.Time, connect bool) *latencyMeter {
+	var report func(LatencyResult)
+	if c.onInterval != nil {
+		report = func(r LatencyResult) {
+			c.onInterval(*c.result(&r, connect))
+		}
+	}
+	return newLatencyMeter(c.numMsg, c.interval, report, start)
+}
+
+// result returns the TransactionResult of the transaction times collected
+// in r.
+func (c TransactionClient) result(r *LatencyResult, connect bool) *TransactionResult {
+	t := &TransactionResult{
+		// a latency result counts both messages of a round trip
+		Transactions: r.NumMsg / 2,
+		Elapsed:      r.ElapsedTime,
+		ReqSize:      c.reqSize,
+		RespSize:     c.respSize,
+		Connect:      connect,
+		LatencyStats: r.LatencyStats,
+		Samples:      r.Samples,
+		Start:        r.Start,
+	}
+	if r.ElapsedTime > 0 {
+		t.Rate = float64(t.Transactions) / r.ElapsedTime.Seconds()
+	}
+	for i := range r.Intervals {
+		t.Intervals = append(t.Intervals, *c.result(&r.Intervals[i], connect))
+	}
+	return t
+}
+
+// outcome returns the result of a run that ended with err. If the run was
+// interrupted because ctx is done, the result of the transactions done
+// until then is returned along with an error wrapping the context error.
+func (c TransactionClient) outcome(ctx context.Context, meter *latencyMeter, connect bool, err error) (*TransactionResult, error) {
+	ctxErr := interrupted(ctx, err)
+	if ctxErr == nil {
+		return nil, err
+	}
+	if meter == nil {
+		return nil, ctxErr
+	}
+	return c.result(meter.result(time.Now()), connect), ctxErr
+}
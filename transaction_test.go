@@ -0,0 +1,154 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTransaction(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultTransactionOptions()
+	o.NumMsg = 1000
+	o.ReqSize = 100
+	o.RespSize = 10000
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+
+	results := make(chan *ServerResult, 1)
+	go func() {
+		result, err := NewServer().Run(l)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+
+	result, err := o.TransactionClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running transaction test: %v", err)
+	}
+	conn.Close()
+
+	if result.Transactions != o.NumMsg || len(result.Samples) != o.NumMsg {
+		t.Errorf("expected %d transactions, got %d", o.NumMsg, result.Transactions)
+	}
+	if result.ReqSize != o.ReqSize || result.RespSize != o.RespSize || result.Connect {
+		t.Errorf("expected transactions of %d/%d bytes over one connection, got %+v", o.ReqSize, o.RespSize, result)
+	}
+	if result.Rate <= 0 || result.Min <= 0 {
+		t.Errorf("expected transaction rate and times, got %+v", result)
+	}
+	if r := <-results; r == nil || r.Type != TestTypeTransaction {
+		t.Errorf("expected transaction test, got %+v", r)
+	}
+	t.Logf("%.0f transactions/s", result.Rate)
+}
+
+func TestTransactionConnect(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultTransactionOptions()
+	o.NumMsg = 100
+	o.Interval = 10
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		_ = o.Server().Serve(l)
+	}()
+
+	dials, intervals := 0, 0
+	result, err := o.TransactionClient().OnInterval(func(r TransactionResult) {
+		intervals += r.Transactions
+	}).RunConnect(func(ctx context.Context) (net.Conn, error) {
+		dials++
+		return net.Dial(o.Network, o.Addr)
+	})
+	if err != nil {
+		t.Fatalf("Error running transaction test: %v", err)
+	}
+
+	if result.Transactions != o.NumMsg || dials != o.NumMsg || !result.Connect {
+		t.Errorf("expected %d transactions over a connection each, got %d over %d connections", o.NumMsg, result.Transactions, dials)
+	}
+	if intervals != result.Transactions {
+		t.Errorf("expected intervals to add up to %d transactions, got %d", result.Transactions, intervals)
+	}
+	t.Logf("%.0f transactions/s", result.Rate)
+}
+
+func TestTransactionRejected(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultTransactionOptions()
+	o.Addr = fmt.Sprintf(":%d", randPort())
+
+	l, err := net.Listen(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		_, _ = NewServer().Run(l)
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	// the response size has to be set
+	if _, err := NewTransactionClient(1, 0, 10, 1000).Run(conn); err == nil {
+		t.Error("expected server to reject the test")
+	}
+
+	// and fit in memory
+	go func() {
+		_, _ = NewServer().Run(l)
+	}()
+	conn, err = net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	desc := TestDescriptor{Type: TestTypeTransaction, MsgSize: 1, RespSize: 1 << 40, NumMsg: 1}
+	if err := handshake(context.Background(), conn, desc); err == nil {
+		t.Error("expected server to reject the response size")
+	}
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Transport connects the clients and servers of tests. Transports are
+// selected by the network of the options, see RegisterTransport. Options
+// passed to a transport carry its settings, like the address.
+type Transport interface {
+	// DialContext connects to the server at the address of the options.
+	DialContext(ctx context.Context, o Options) (net.Conn, error)
+	// Listen listens for clients on the address of the options.
+	Listen(o Options) (net.Listener, error)
+}
+
+// transports holds the registered transports by network.
+var transports = struct {
+	sync.RWMutex
+	m map[string]Transport
+}{
+	m: map[string]Transport{
+		"tcp":      streamTransport{},
+		"tcp4":     streamTransport{},
+		"tcp6":     streamTransport{},
+		"unix":     streamTransport{},
+		"tls":      streamTransport{tls: true},
+		"udp":      packetTransport{},
+		"udp4":     packetTransport{},
+		"udp6":     packetTransport{},
+		"unixgram": packetTransport{},
+	},
+}
+
+// RegisterTransport makes t the transport of network, replacing the one
+// registered before if any. tcp, tcp4, tcp6, unix, udp, udp4, udp6 and
+// unixgram are registered by default and use the networks of the net
+// package. tls is TCP with the TLS options of the options, which are
+// required then.
+func RegisterTransport(network string, t Transport) {
+	if t == nil {
+		panic("benchmate: RegisterTransport transport is nil")
+	}
+	transports.Lock()
+	defer transports.Unlock()
+	transports.m[network] = t
+}
+
+// transport returns the transport registered for network.
+func transport(network string) (Transport, error) {
+	transports.RLock()
+	defer transports.RUnlock()
+	t, ok := transports.m[network]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q", network)
+	}
+	return t, nil
+}
+
+// Dial connects to the server at the address of the options over the
+// transport of their network, see DialContext.
+func (o Options) Dial() (net.Conn, error) {
+	return o.DialContext(context.Background())
+}
+
+// DialContext connects to the server at the address of the options over
+// the transport of their network. The connection uses TLS if the options
+// configure it, whatever the transport. DialContext is a DialFunc.
+func (o Options) DialContext(ctx context.Context) (net.Conn, error) {
+	t, err := transport(o.Network)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := t.DialContext(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := o.WrapConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Listen listens for clients on the address of the options over the
+// transport of their network. The listener serves TLS if the options
+// configure it, whatever the transport.
+func (o Options) Listen() (net.Listener, error) {
+	t, err := transport(o.Network)
+	if err != nil {
+		return nil, err
+	}
+	l, err := t.Listen(o)
+	if err != nil {
+		return nil, err
+	}
+	tl, err := o.WrapListener(l)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return tl, nil
+}
+
+// errTLSRequired rejects the tls network without TLS options.
+var errTLSRequired = errors.New("the tls network needs tls options")
+
+// dialer returns a dialer for network that binds clients to the source
+// address, client port and interface of the options and sets their socket
+// options. TCP clients reuse their port while connections of earlier runs
+// from it linger in TIME_WAIT.
+func (o Options) dialer(network string) (*net.Dialer, error) {
+	d := &net.Dialer{}
+	if o.SourceAddr != "" || o.ClientPort != 0 {
+		laddr, err := localAddr(network, o.SourceAddr, o.ClientPort)
+		if err != nil {
+			return nil, err
+		}
+		d.LocalAddr = laddr
+	}
+	var reuse, bind func(network, address string, c syscall.RawConn) error
+	if o.ClientPort != 0 && strings.HasPrefix(network, "tcp") {
+		reuse = func(_, _ string, c syscall.RawConn) error {
+			return reuseAddr(c)
+		}
+	}
+	if o.Interface != "" {
+		if strings.HasPrefix(network, "unix") {
+			return nil, fmt.Errorf("cannot bind %s sockets to an interface", network)
+		}
+		iface := o.Interface
+		bind = func(_, _ string, c syscall.RawConn) error {
+			return bindToDevice(c, iface)
+		}
+	}
+	tune := o.Socket.control()
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		for _, control := range []func(string, string, syscall.RawConn) error{reuse, bind, tune} {
+			if control == nil {
+				continue
+			}
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return d, nil
+}
+
+// listenConfig returns the configuration of listeners with the socket
+// options of the options.
+func (o Options) listenConfig() *net.ListenConfig {
+	return &net.ListenConfig{Control: o.Socket.control()}
+}
+
+// localAddr returns the local address of network with the IP address ip
+// and port.
+func localAddr(network, ip string, port int) (net.Addr, error) {
+	var addr net.IP
+	if ip != "" {
+		if addr = net.ParseIP(ip); addr == nil {
+			return nil, fmt.Errorf("bad source address %q", ip)
+		}
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return &net.TCPAddr{IP: addr, Port: port}, nil
+	case "udp", "udp4", "udp6":
+		return &net.UDPAddr{IP: addr, Port: port}, nil
+	default:
+		return nil, fmt.Errorf("source address and client port need an ip network, got %s", network)
+	}
+}
+
+// streamTransport dials and listens on stream networks of the net package.
+// The tls network is tcp with TLS required.
+type streamTransport struct {
+	tls bool
+}
+
+// DialContext implements Transport.
+func (t streamTransport) DialContext(ctx context.Context, o Options) (net.Conn, error) {
+	network, err := t.network(o)
+	if err != nil {
+		return nil, err
+	}
+	d, err := o.dialer(network)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.DialContext(ctx, network, o.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.Socket.tune(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Listen implements Transport.
+func (t streamTransport) Listen(o Options) (net.Listener, error) {
+	network, err := t.network(o)
+	if err != nil {
+		return nil, err
+	}
+	l, err := o.listenConfig().Listen(context.Background(), network, o.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if o.Socket != nil {
+		l = tunedListener{Listener: l, socket: o.Socket}
+	}
+	return l, nil
+}
+
+// network returns the network of the net package to use for o.
+func (t streamTransport) network(o Options) (string, error) {
+	if !t.tls {
+		return o.Network, nil
+	}
+	if o.TLS == nil {
+		return "", errTLSRequired
+	}
+	return "tcp", nil
+}
+
+// packetTransport dials and listens on datagram networks of the net
+// package. Its listeners accept a connection for every client, see
+// NewPacketListener.
+type packetTransport struct{}
+
+// DialContext implements Transport. Unixgram sockets are bound to an
+// address of their own first, otherwise the server cannot reply. The
+// socket file is removed when the connection is closed.
+func (packetTransport) DialContext(ctx context.Context, o Options) (net.Conn, error) {
+	d, err := o.dialer(o.Network)
+	if err != nil {
+		return nil, err
+	}
+	if o.Network != "unixgram" {
+		return d.DialContext(ctx, o.Network, o.Addr)
+	}
+
+	f, err := ioutil.TempFile("", "benchmate-*.sock")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	os.Remove(f.Name())
+	d.LocalAddr = &net.UnixAddr{Name: f.Name(), Net: o.Network}
+	conn, err := d.DialContext(ctx, o.Network, o.Addr)
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return unixgramConn{conn.(*net.UnixConn)}, nil
+}
+
+// Listen implements Transport.
+func (packetTransport) Listen(o Options) (net.Listener, error) {
+	pc, err := o.listenConfig().ListenPacket(context.Background(), o.Network, o.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewPacketListener(pc), nil
+}
+
+// unixgramConn removes the socket file of the client when it is closed.
+type unixgramConn struct {
+	*net.UnixConn
+}
+
+// Close closes the connection and removes its socket file.
+func (c unixgramConn) Close() error {
+	defer os.Remove(c.LocalAddr().String())
+	return c.UnixConn.Close()
+}
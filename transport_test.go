@@ -0,0 +1,205 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTransport is a custom transport counting its connections.
+type countingTransport struct {
+	dials, listens *int32
+}
+
+func (t countingTransport) DialContext(ctx context.Context, o Options) (net.Conn, error) {
+	atomic.AddInt32(t.dials, 1)
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", o.Addr)
+}
+
+func (t countingTransport) Listen(o Options) (net.Listener, error) {
+	atomic.AddInt32(t.listens, 1)
+	return net.Listen("tcp", o.Addr)
+}
+
+func TestTransport(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	var dials, listens int32
+	RegisterTransport("counting", countingTransport{dials: &dials, listens: &listens})
+
+	o := DefaultLatencyOptions()
+	o.Network = "counting"
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	o.NumMsg = 100
+
+	l, err := o.Listen()
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		_, _ = NewServer().Run(l)
+	}()
+
+	conn, err := o.Dial()
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := o.LatencyClient().Run(conn); err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+	if dials != 1 || listens != 1 {
+		t.Errorf("expected the transport to dial and listen once, got %d dials and %d listens", dials, listens)
+	}
+
+	o.Network = "carrier-pigeon"
+	if _, err := o.Dial(); err == nil {
+		t.Error("expected unknown network to be rejected")
+	}
+	if _, err := o.Listen(); err == nil {
+		t.Error("expected unknown network to be rejected")
+	}
+}
+
+func TestTransportTLS(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	dir, err := ioutil.TempDir("", "benchmate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverTLS, clientTLS := writeCerts(t, dir)
+
+	o := DefaultTransactionOptions()
+	o.Network = "tls"
+	o.Addr = fmt.Sprintf("localhost:%d", randPort())
+	o.NumMsg = 100
+	if _, err := o.Listen(); err == nil {
+		t.Fatal("expected tls network without tls options to be rejected")
+	}
+
+	so, co := o, o
+	so.TLS, co.TLS = &serverTLS, &clientTLS
+	l, err := so.Listen()
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		_ = so.Server().Serve(l)
+	}()
+
+	result, err := co.TransactionClient().RunConnect(co.DialContext)
+	if err != nil {
+		t.Fatalf("Error running transaction test: %v", err)
+	}
+	if result.Transactions != o.NumMsg || result.HandshakeTime <= 0 {
+		t.Errorf("expected %d transactions over tls, got %+v", o.NumMsg, result)
+	}
+}
+
+func TestTransportUnixgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benchmate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o := DefaultLatencyOptions()
+	o.Network = "unixgram"
+	o.Addr = filepath.Join(dir, "server.sock")
+	o.NumMsg = 100
+
+	l, err := o.Listen()
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		_, _ = NewServer().Run(l)
+	}()
+
+	conn, err := o.Dial()
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	if _, err := o.LatencyClient().Run(conn); err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+
+	// the socket file of the client is removed along with the connection
+	local := conn.LocalAddr().String()
+	conn.Close()
+	if _, err := os.Stat(local); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", local, err)
+	}
+}
+
+func TestTransportSourceAddr(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	o.SourceAddr = "127.0.0.1"
+	o.ClientPort = randPort() + 2000
+
+	l, err := o.Listen()
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	defer l.Close()
+	remote := make(chan net.Addr, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		remote <- conn.RemoteAddr()
+	}()
+
+	conn, err := o.Dial()
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+	expected := fmt.Sprintf("%s:%d", o.SourceAddr, o.ClientPort)
+	if addr := <-remote; addr.String() != expected {
+		t.Errorf("expected server to see client at %s, got %v", expected, addr)
+	}
+
+	o.SourceAddr = "not-an-ip"
+	if _, err := o.Dial(); err == nil {
+		t.Error("expected bad source address to be rejected")
+	}
+	o.Network, o.SourceAddr = "unix", "127.0.0.1"
+	if _, err := o.Dial(); err == nil {
+		t.Error("expected source address of unix socket to be rejected")
+	}
+}
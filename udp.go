@@ -0,0 +1,506 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultDatagramSize is the message size of runs over datagram
+	// sockets that fits the MTU of common overlay networks.
+	DefaultDatagramSize = 1200
+
+	// DefaultDatagramBitrate is the bitrate in bits per second of throughput
+	// runs over datagram sockets that are not configured with one.
+	DefaultDatagramBitrate = 10 * 1000 * 1000
+
+	// DefaultPingTimeout is the time a latency client waits for the reply
+	// to a ping over a datagram socket if it is not configured otherwise.
+	DefaultPingTimeout = time.Second
+)
+
+// Kinds of datagrams of throughput runs over datagram sockets.
+const (
+	kindData   = 'D' // a message sent by the sender
+	kindFin    = 'F' // the sender is done, carries the number of messages sent
+	kindReport = 'R' // the statistics of the receiver, JSON encoded
+	kindPing   = 'P' // a ping of a latency run, sent back as is
+)
+
+const (
+	// packetHeaderSize is the size of the header of a datagram: its kind,
+	// the sequence number and the send time in nanoseconds since the epoch.
+	packetHeaderSize = 17
+
+	// maxDatagramSize is the maximum payload of a UDP datagram.
+	maxDatagramSize = 65507
+
+	// finInterval is the time the sender waits for the report of the
+	// receiver before it sends the fin datagram again.
+	finInterval = 250 * time.Millisecond
+
+	// finRetries is the number of fin datagrams the sender sends before it
+	// gives up on the report.
+	finRetries = 8
+
+	// finLinger is the time the receiver keeps answering repeated fin
+	// datagrams, in case its report got lost.
+	finLinger = time.Second
+
+	// maxReorder limits how far ahead of or behind the highest sequence
+	// number seen a datagram may be, anything beyond is ignored. It is the
+	// size of the window of sequence numbers the receiver keeps track of.
+	maxReorder = 1 << 20
+
+	// finCount is the number of fin datagrams a latency client sends at the
+	// end of a run. The server does not answer them, it stops waiting for
+	// pings once it gets one of them or the client is idle for too long.
+	finCount = 3
+)
+
+// UDPStats contains what the receiver of a throughput run over a datagram
+// socket saw of the datagrams sent.
+type UDPStats struct {
+	Sent        int           `json:"sent"`        // number of datagrams sent
+	Received    int           `json:"received"`    // number of datagrams received, including duplicates
+	Lost        int           `json:"lost"`        // number of datagrams sent but never received
+	LossPercent float64       `json:"lossPercent"` // lost datagrams in percent of the sent ones
+	OutOfOrder  int           `json:"outOfOrder"`  // number of datagrams received after one sent later
+	Duplicates  int           `json:"duplicates"`  // number of datagrams received more than once
+	Jitter      time.Duration `json:"jitter"`      // interarrival jitter as defined in RFC 3550
+}
+
+// packetReport is the report the receiver sends to the sender.
+type packetReport struct {
+	Bytes   int64         `json:"bytes"`
+	Elapsed time.Duration `json:"elapsed"`
+	UDPStats
+}
+
+// checkDatagram returns an error if the configuration cannot be used for
+// runs over datagram sockets.
+func (c throughputConfig) checkDatagram() error {
+	if c.msgSize < packetHeaderSize || c.msgSize > maxDatagramSize {
+		return fmt.Errorf("message size %d does not fit in a datagram, it must be between %d and %d", c.msgSize, packetHeaderSize, maxDatagramSize)
+	}
+	if c.direction != "" && c.direction != DirectionForward {
+		return fmt.Errorf("direction %s is not supported over datagram sockets", c.direction)
+	}
+	if c.streams() > 1 {
+		return errors.New("parallel streams are not supported over datagram sockets")
+	}
+	return nil
+}
+
+// runPacketSender sends datagrams over the connection and waits for the
+// receiver to report back.
+func (c throughputConfig) runPacketSender(d *deadlines) (*ThroughputResult, error) {
+	result, sent, err := c.sendPackets(d)
+	result.Direction = c.direction
+	if err != nil {
+		return result, err
+	}
+
+	report, err := c.awaitPacketReport(d, sent)
+	if err != nil {
+		return result, err
+	}
+	result.setReceiver(report.Bytes, report.Elapsed)
+	result.UDP = &report.UDPStats
+
+	return result, nil
+}
+
+// sendPackets sends the configured number of datagrams or keeps sending
+// them for the configured duration, at the configured bitrate. It returns
+// the number of datagrams sent along with the result, which is never nil.
+func (c throughputConfig) sendPackets(d *deadlines) (*ThroughputResult, uint64, error) {
+	bitrate := c.bitrate
+	if bitrate <= 0 {
+		bitrate = DefaultDatagramBitrate
+	}
+
+	buf := make([]byte, c.msgSize)
+	buf[0] = kindData
+	t1 := time.Now()
+	stopTime := t1.Add(time.Duration(c.timeout) * time.Millisecond)
+	if c.duration > 0 {
+		stopTime = t1.Add(time.Duration(c.duration) * time.Millisecond)
+	}
+	meter := newThroughputMeter(c.msgSize, c.interval, c.onInterval, t1)
+	p := newPacer(bitrate, t1)
+	if c.duration > 0 {
+		p.until = stopTime
+	}
+
+	var seq uint64
+	for c.duration > 0 || seq < uint64(c.numMsg) {
+		if err := p.wait(d.ctx, len(buf)); err != nil {
+			return meter.result(time.Now()), seq, err
+		}
+		if c.duration > 0 && !time.Now().Before(stopTime) {
+			break
+		}
+
+		binary.BigEndian.PutUint64(buf[1:], seq)
+		binary.BigEndian.PutUint64(buf[9:], uint64(time.Now().UnixNano()))
+		nwrite, err := d.conn.Write(buf)
+		now := time.Now()
+		if err != nil {
+			return meter.result(now), seq, err
+		}
+		meter.add(nwrite, now)
+		seq++
+
+		if now.After(stopTime) {
+			break
+		}
+	}
+
+	return meter.result(time.Now()), seq, nil
+}
+
+// awaitPacketReport tells the receiver how many datagrams were sent and
+// waits for its report. The fin datagram is repeated until the report
+// arrives since either of them may get lost.
+func (c throughputConfig) awaitPacketReport(d *deadlines, sent uint64) (*packetReport, error) {
+	defer d.setRead(time.Time{})
+
+	fin := make([]byte, packetHeaderSize)
+	fin[0] = kindFin
+	binary.BigEndian.PutUint64(fin[1:], sent)
+	buf := make([]byte, maxDatagramSize)
+	for i := 0; i < finRetries; i++ {
+		if _, err := d.conn.Write(fin); err != nil {
+			return nil, err
+		}
+
+		d.setRead(time.Now().Add(finInterval))
+		for {
+			n, err := d.conn.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) && d.ctx.Err() == nil {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 && buf[0] == kindReport {
+				report := new(packetReport)
+				return report, json.Unmarshal(buf[1:n], report)
+			}
+		}
+	}
+
+	return nil, errors.New("receiver did not report back")
+}
+
+// receivePackets reads datagrams from the connection until the sender is
+// done and reports the receiver side statistics back to the sender. The
+// elapsed time of the result is measured from the first to the last
+// datagram received. The result is never nil.
+func (c throughputConfig) receivePackets(d *deadlines) (*ThroughputResult, error) {
+	defer d.setRead(time.Time{})
+
+	// the sender may be gone without sending a fin
+	idle := time.Duration(c.timeout) * time.Millisecond
+	if idle <= 0 {
+		idle = handshakeTimeout
+	}
+
+	buf := make([]byte, maxDatagramSize)
+	var meter *throughputMeter
+	var last time.Time
+	stats := &packetStats{}
+	result := func() *ThroughputResult {
+		var r *ThroughputResult
+		if meter == nil {
+			r = newThroughputResult(c.msgSize, 0, 0)
+		} else {
+			r = meter.result(last)
+		}
+		r.setReceiver(r.Bytes, r.Elapsed)
+		r.Direction = c.direction
+		return r
+	}
+
+	for {
+		d.setRead(time.Now().Add(idle))
+		n, err := d.conn.Read(buf)
+		now := time.Now()
+		if err != nil {
+			return result(), err
+		}
+		if n < packetHeaderSize {
+			continue
+		}
+
+		seq := binary.BigEndian.Uint64(buf[1:])
+		switch buf[0] {
+		case kindData:
+			if meter == nil {
+				meter = newThroughputMeter(c.msgSize, c.interval, c.onInterval, now)
+			}
+			meter.add(n, now)
+			last = now
+			sent := time.Unix(0, int64(binary.BigEndian.Uint64(buf[9:])))
+			stats.add(seq, sent, now)
+		case kindFin:
+			r := result()
+			udp := stats.result(seq)
+			r.UDP = &udp
+			report, err := json.Marshal(packetReport{Bytes: r.Bytes, Elapsed: r.Elapsed, UDPStats: udp})
+			if err != nil {
+				return r, err
+			}
+			c.sendPacketReport(d, append([]byte{kindReport}, report...))
+			return r, nil
+		}
+	}
+}
+
+// sendPacketReport sends the report to the sender and sends it again for
+// every repeated fin datagram for a while.
+func (c throughputConfig) sendPacketReport(d *deadlines, report []byte) {
+	buf := make([]byte, maxDatagramSize)
+	stop := time.Now().Add(finLinger)
+	for {
+		if _, err := d.conn.Write(report); err != nil {
+			return
+		}
+		for {
+			d.setRead(stop)
+			n, err := d.conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if n > 0 && buf[0] == kindFin {
+				break
+			}
+		}
+	}
+}
+
+// packetStats tracks the sequence numbers and transit times of the
+// datagrams received.
+type packetStats struct {
+	UDPStats
+	seen       []uint64 // ring bitmap of the sequence numbers received in the window of maxReorder before next
+	next       uint64   // highest sequence number received plus one
+	transit    time.Duration
+	hasTransit bool
+	jitter     float64
+}
+
+// add records a datagram with sequence number seq that was sent at sent
+// and received at now. The clocks of sender and receiver need not be in
+// sync, only differences of transit times are used for the jitter.
+func (s *packetStats) add(seq uint64, sent, now time.Time) {
+	if seq >= s.next && seq-s.next >= maxReorder || seq < s.next && s.next-seq > maxReorder {
+		return
+	}
+
+	if s.seen == nil {
+		s.seen = make([]uint64, maxReorder/64)
+	}
+	if seq >= s.next {
+		s.forget(s.next, seq)
+	}
+	s.Received++
+	word, bit := seq%maxReorder/64, uint64(1)<<(seq%64)
+	if s.seen[word]&bit != 0 {
+		s.Duplicates++
+		return
+	}
+	s.seen[word] |= bit
+
+	if seq < s.next {
+		s.OutOfOrder++
+	} else {
+		s.next = seq + 1
+	}
+
+	transit := now.Sub(sent)
+	if s.hasTransit {
+		delta := transit - s.transit
+		if delta < 0 {
+			delta = -delta
+		}
+		s.jitter += (float64(delta) - s.jitter) / 16
+	}
+	s.transit, s.hasTransit = transit, true
+}
+
+// forget clears the bits of the sequence numbers from up to and including
+// to as the window moves on to them, their bits are reused from sequence
+// numbers that left the window.
+func (s *packetStats) forget(from, to uint64) {
+	if to-from >= maxReorder {
+		for i := range s.seen {
+			s.seen[i] = 0
+		}
+		return
+	}
+	for seq := from; seq <= to; seq++ {
+		s.seen[seq%maxReorder/64] &^= 1 << (seq % 64)
+	}
+}
+
+// result returns the statistics of a run in which sent datagrams were sent.
+func (s *packetStats) result(sent uint64) UDPStats {
+	r := s.UDPStats
+	r.Sent = int(sent)
+	if lost := r.Sent - (r.Received - r.Duplicates); lost > 0 {
+		r.Lost = lost
+	}
+	if r.Sent > 0 {
+		r.LossPercent = float64(r.Lost) * 100 / float64(r.Sent)
+	}
+	r.Jitter = time.Duration(s.jitter)
+	return r
+}
+
+// pingPackets sends every ping as a datagram and waits for its reply until
+// the ping timeout. Pings without a reply in time are counted as lost,
+// late replies are ignored.
+func (lm LatencyClient) pingPackets(d *deadlines, meter *latencyMeter, start time.Time) error {
+	if err := lm.checkPackets(); err != nil {
+		return err
+	}
+	pingTimeout := lm.packetPingTimeout()
+	defer d.setRead(time.Time{})
+
+	buf := make([]byte, lm.msgSize)
+	buf[0] = kindPing
+	reply := make([]byte, maxDatagramSize)
+	stopTime := start.Add(time.Duration(lm.timeout) * time.Millisecond)
+	for seq := uint64(0); seq < uint64(lm.numMsg); seq++ {
+		sent := time.Now()
+		binary.BigEndian.PutUint64(buf[1:], seq)
+		binary.BigEndian.PutUint64(buf[9:], uint64(sent.UnixNano()))
+		if _, err := d.conn.Write(buf); err != nil {
+			return err
+		}
+
+		d.setRead(sent.Add(pingTimeout))
+		answered, err := awaitPong(d, reply, seq)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if answered {
+			meter.add(now.Sub(sent), now)
+		} else {
+			meter.addLost(now)
+		}
+		if now.After(stopTime) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// checkPackets returns an error if the pings do not fit in a datagram.
+func (lm LatencyClient) checkPackets() error {
+	if lm.msgSize < packetHeaderSize || lm.msgSize > maxDatagramSize {
+		return fmt.Errorf("message size %d does not fit in a datagram, it must be between %d and %d", lm.msgSize, packetHeaderSize, maxDatagramSize)
+	}
+	return nil
+}
+
+// packetPingTimeout returns the time to wait for the reply to a ping
+// datagram.
+func (lm LatencyClient) packetPingTimeout() time.Duration {
+	if lm.pingTimeout > 0 {
+		return time.Duration(lm.pingTimeout) * time.Millisecond
+	}
+	return DefaultPingTimeout
+}
+
+// sendPingFins tells the server that the client is done with the pings
+// over a datagram socket.
+func sendPingFins(d *deadlines) error {
+	fin := make([]byte, packetHeaderSize)
+	fin[0] = kindFin
+	for i := 0; i < finCount; i++ {
+		if _, err := d.conn.Write(fin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// awaitPong reads datagrams until the reply to the ping with sequence
+// number seq arrives. It returns false if the read deadline passes before.
+func awaitPong(d *deadlines, buf []byte, seq uint64) (bool, error) {
+	for {
+		n, err := d.conn.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) && d.ctx.Err() == nil {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if n >= packetHeaderSize && buf[0] == kindPing && binary.BigEndian.Uint64(buf[1:]) == seq {
+			return true, nil
+		}
+	}
+}
+
+// echoPackets sends every ping datagram back until the client is done.
+// Since the client does not get to know whether the fin datagrams arrive,
+// the server also stops if no ping arrives for a while.
+func (o LatencyServer) echoPackets(d *deadlines) (int, error) {
+	defer d.setRead(time.Time{})
+
+	idle := handshakeTimeout
+	if pingTimeout := 2 * time.Duration(o.pingTimeout) * time.Millisecond; pingTimeout > idle {
+		idle = pingTimeout
+	}
+
+	buf := make([]byte, maxDatagramSize)
+	pings := 0
+	for {
+		d.setRead(time.Now().Add(idle))
+		n, err := d.conn.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) && d.ctx.Err() == nil {
+			return pings, nil
+		}
+		if err != nil {
+			return pings, err
+		}
+		if n < packetHeaderSize {
+			continue
+		}
+
+		switch buf[0] {
+		case kindPing:
+			if _, err := d.conn.Write(buf[:n]); err != nil {
+				return pings, err
+			}
+			pings++
+		case kindFin:
+			return pings, nil
+		}
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2021 The Kubermatic Kubernetes Platform contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmate
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestThroughputUDP(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultThroughputOptions()
+	o.Network = "udp"
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	o.MsgSize = DefaultDatagramSize
+	o.NumMsg = 1000
+	o.Bitrate = 100 * 1000 * 1000
+
+	pc, err := net.ListenPacket(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	l := NewPacketListener(pc)
+	defer l.Close()
+
+	serverResult := make(chan *ServerResult, 1)
+	go func() {
+		result, err := NewServer().Run(l)
+		if err != nil {
+			t.Error(err)
+		}
+		serverResult <- result
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	result, err := o.ThroughputClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running throughput test: %v", err)
+	}
+
+	// 1000 datagrams of 1200 bytes at 100 Mbit/s take about 96ms
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("expected the client to be paced to the bitrate, took %v", elapsed)
+	}
+	if result.UDP == nil {
+		t.Fatal("expected datagram statistics")
+	}
+	if result.UDP.Sent != o.NumMsg || result.UDP.Received-result.UDP.Duplicates+result.UDP.Lost != o.NumMsg {
+		t.Errorf("expected %d datagrams to be received or lost, got %+v", o.NumMsg, result.UDP)
+	}
+	if result.ReceivedBytes != int64(result.UDP.Received*o.MsgSize) {
+		t.Errorf("expected server to receive %d bytes, got %d", result.UDP.Received*o.MsgSize, result.ReceivedBytes)
+	}
+
+	server := <-serverResult
+	if server == nil || server.Throughput == nil || server.Throughput.UDP == nil || server.Throughput.UDP.Sent != o.NumMsg {
+		t.Errorf("expected server to see %d datagrams sent, got %+v", o.NumMsg, server)
+	}
+}
+
+func TestThroughputUDPUnsupported(t *testing.T) {
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", randPort()))
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	o := DefaultThroughputOptions()
+	if _, err := o.ThroughputClient().Run(conn); err == nil {
+		t.Errorf("expected message size %d to be rejected", o.MsgSize)
+	}
+
+	o.MsgSize = DefaultDatagramSize
+	o.Direction = DirectionReverse
+	if _, err := o.ThroughputClient().Run(conn); err == nil {
+		t.Error("expected reverse direction to be rejected")
+	}
+}
+
+func TestPacketStats(t *testing.T) {
+	start := time.Now()
+	s := &packetStats{}
+	// 2 is duplicated, 3 comes after 4, 5 is lost, transit times alternate
+	for i, seq := range []uint64{0, 1, 2, 2, 4, 3, 6, 7} {
+		transit := time.Millisecond
+		if i%2 == 1 {
+			transit = 2 * time.Millisecond
+		}
+		sent := start.Add(time.Duration(i) * 10 * time.Millisecond)
+		s.add(seq, sent, sent.Add(transit))
+	}
+
+	r := s.result(8)
+	expected := UDPStats{Sent: 8, Received: 8, Lost: 1, LossPercent: 12.5, OutOfOrder: 1, Duplicates: 1}
+	jitter := r.Jitter
+	r.Jitter = 0
+	if r != expected {
+		t.Errorf("expected %+v, got %+v", expected, r)
+	}
+	// the jitter converges towards the 1ms difference of transit times
+	if jitter <= 0 || jitter >= time.Millisecond {
+		t.Errorf("expected jitter between 0 and 1ms, got %v", jitter)
+	}
+
+	// the window of sequence numbers moves on without growing, datagrams
+	// too far ahead or behind it are ignored
+	s = &packetStats{}
+	for _, seq := range []uint64{0, 1 << 40, maxReorder - 1, 2*maxReorder - 1, 2*maxReorder - 2, maxReorder - 1, 2*maxReorder - 1, maxReorder} {
+		s.add(seq, start, start)
+	}
+	r = s.result(2 * maxReorder)
+	if r.Received != 6 || r.OutOfOrder != 2 || r.Duplicates != 1 {
+		t.Errorf("expected 6 received, 2 out of order and 1 duplicate, got %+v", r)
+	}
+	if len(s.seen) != maxReorder/64 {
+		t.Errorf("expected a window of %d words, got %d", maxReorder/64, len(s.seen))
+	}
+}
+
+func TestLatencyUDP(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	o := DefaultLatencyOptions()
+	o.Network = "udp"
+	o.Addr = fmt.Sprintf("127.0.0.1:%d", randPort())
+	o.NumMsg = 100
+	o.PingTimeout = 50
+
+	pc, err := net.ListenPacket(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	l := NewPacketListener(pc)
+	defer l.Close()
+
+	// a server that drops every 10th ping and answers every 5th one twice
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := readTest(context.Background(), conn); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := replyTest(conn, nil); err != nil {
+			t.Error(err)
+			return
+		}
+
+		buf := make([]byte, maxDatagramSize)
+		for i := 0; ; i++ {
+			n, err := conn.Read(buf)
+			if err != nil || buf[0] == kindFin {
+				return
+			}
+			if i%10 == 9 {
+				continue
+			}
+			_, _ = conn.Write(buf[:n])
+			if i%5 == 4 {
+				_, _ = conn.Write(buf[:n])
+			}
+		}
+	}()
+
+	conn, err := net.Dial(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := o.LatencyClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+	if result.Lost != 10 || len(result.Samples) != 90 {
+		t.Errorf("expected 10 lost and 90 answered pings, got %d lost and %d answered", result.Lost, len(result.Samples))
+	}
+	if result.Max >= 50*time.Millisecond {
+		t.Errorf("expected round-trip times below the ping timeout, got %v", result.Max)
+	}
+}
+
+func TestLatencyUnixgram(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	dir, err := ioutil.TempDir("", "benchmate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o := DefaultLatencyOptions()
+	o.Network = "unixgram"
+	o.Addr = filepath.Join(dir, "server.sock")
+	o.NumMsg = 100
+
+	pc, err := net.ListenPacket(o.Network, o.Addr)
+	if err != nil {
+		t.Fatalf("Error making listener: %v", err)
+	}
+	l := NewPacketListener(pc)
+	defer l.Close()
+
+	go func() {
+		_, _ = NewServer().Run(l)
+	}()
+
+	// the client needs an address of its own for the replies
+	laddr := &net.UnixAddr{Name: filepath.Join(dir, "client.sock"), Net: o.Network}
+	conn, err := net.DialUnix(o.Network, laddr, &net.UnixAddr{Name: o.Addr, Net: o.Network})
+	if err != nil {
+		t.Fatalf("Error making connection: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := o.LatencyClient().Run(conn)
+	if err != nil {
+		t.Fatalf("Error running latency test: %v", err)
+	}
+	if result.Lost != 0 || result.NumMsg != 2*o.NumMsg {
+		t.Errorf("expected %d pings without loss, got %d with %d lost", o.NumMsg, result.NumMsg/2, result.Lost)
+	}
+}